@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Analysis is a pass over the SSA form of a package.  It reports
+// diagnostics and annotates functions, blocks and instructions with
+// notes, or computes a view shown next to them, e.g. the liveness or the
+// loops of every function.  Analyses register themselves with register,
+// usually from an init function in their own file, and are listed and run
+// by name in the UI, the API and the command line.
+type Analysis interface {
+	Name() string
+	Doc() string
+	Run(in *AnalysisInput) (*Report, error)
+}
+
+// AnalysisInput is the package an analysis runs on, with the results
+// shared by all analyses.  Long running analyses stop with errDeadline
+// once the context is done.
+type AnalysisInput struct {
+	Pkg     *ssa.Package
+	Funcs   []*ssa.Function // the functions of Pkg, see pkgFuncs
+	Options options         // the render options, e.g. the taint spec
+	Sizes   types.Sizes     // of the target
+	Context context.Context
+
+	dus   map[*ssa.Function]*defUse
+	loops map[*ssa.Function]*loopInfo
+	pa    *pointer
+}
+
+// context returns the context of the analyses, which is never done if
+// there is none.
+func (in *AnalysisInput) context() context.Context {
+	if in.Context == nil {
+		return context.Background()
+	}
+	return in.Context
+}
+
+// defUse returns the def-use information of f, computed once for all
+// analyses.
+func (in *AnalysisInput) defUse(f *ssa.Function) *defUse {
+	if in.dus == nil {
+		in.dus = make(map[*ssa.Function]*defUse)
+	}
+	du := in.dus[f]
+	if du == nil {
+		du = newDefUse(f)
+		in.dus[f] = du
+	}
+	return du
+}
+
+// loopsOf returns the loops of f, found once for all analyses.
+func (in *AnalysisInput) loopsOf(f *ssa.Function) *loopInfo {
+	if in.loops == nil {
+		in.loops = make(map[*ssa.Function]*loopInfo)
+	}
+	li := in.loops[f]
+	if li == nil {
+		li = findLoops(f)
+		in.loops[f] = li
+	}
+	return li
+}
+
+// pointers returns the points-to sets of the package, solved once for all
+// analyses and only if one of them needs them.
+func (in *AnalysisInput) pointers() (*pointer, error) {
+	if in.pa == nil {
+		pa, err := analyzePointers(in.context(), in.Pkg)
+		if err != nil {
+			return nil, err
+		}
+		in.pa = pa
+	}
+	return in.pa, nil
+}
+
+// Report is the result of an analysis.
+type Report struct {
+	Diagnostics []Finding
+	Annotations []Finding
+	Views       map[*ssa.Function]interface{} // see viewAnalysis
+	PkgView     interface{}                   // a view of the whole package
+}
+
+// Finding is a message at an instruction, a block or a function.  Block
+// and Func may be left out if Instr is given.  Pos defaults to the
+// position of the instruction or function.  Related findings, e.g. the
+// steps leading to a diagnostic, only use Instr, Pos and Message.
+type Finding struct {
+	Func    *ssa.Function
+	Block   *ssa.BasicBlock
+	Instr   ssa.Instruction
+	Pos     token.Pos
+	Message string
+	Related []Finding
+}
+
+// AnalysisInfo describes a registered analysis.
+type AnalysisInfo struct {
+	Name string
+	Doc  string
+}
+
+var analyses = make(map[string]Analysis)
+
+// register adds a to the registry.  It panics if the name is taken.
+func register(a Analysis) {
+	if _, ok := analyses[a.Name()]; ok {
+		panic("analysis " + a.Name() + " registered twice")
+	}
+	analyses[a.Name()] = a
+}
+
+// analysisList returns the registered analyses ordered by name.
+func analysisList() []AnalysisInfo {
+	var as []AnalysisInfo
+	for _, a := range analyses {
+		as = append(as, AnalysisInfo{a.Name(), a.Doc()})
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Name < as[j].Name })
+	return as
+}
+
+// selectAnalyses returns the analyses of a comma separated list of names,
+// all of them if the list is empty and none for "none".
+func selectAnalyses(names string) ([]Analysis, error) {
+	var as []Analysis
+	if strings.TrimSpace(names) == "none" {
+		return nil, nil
+	}
+	if strings.TrimSpace(names) == "" {
+		for _, info := range analysisList() {
+			as = append(as, analyses[info.Name])
+		}
+		return as, nil
+	}
+	for _, n := range strings.Split(names, ",") {
+		a, ok := analyses[strings.TrimSpace(n)]
+		if !ok {
+			return nil, fmt.Errorf("unknown analysis %q", strings.TrimSpace(n))
+		}
+		as = append(as, a)
+	}
+	return as, nil
+}
+
+// funcAnalysis is an analysis reporting diagnostics for one function at a
+// time.
+type funcAnalysis struct {
+	name, doc string
+	check     func(f *ssa.Function) []Finding
+}
+
+func (a funcAnalysis) Name() string { return a.name }
+func (a funcAnalysis) Doc() string  { return a.doc }
+
+func (a funcAnalysis) Run(in *AnalysisInput) (*Report, error) {
+	r := &Report{}
+	for _, f := range in.Funcs {
+		if in.context().Err() != nil {
+			return nil, errDeadline
+		}
+		r.Diagnostics = append(r.Diagnostics, a.check(f)...)
+	}
+	return r, nil
+}
+
+// viewAnalysis is an analysis computing a view of one function at a time,
+// which is put into the model by buildSSA.
+type viewAnalysis struct {
+	name, doc string
+	view      func(in *AnalysisInput, f *ssa.Function) interface{}
+}
+
+func (a viewAnalysis) Name() string { return a.name }
+func (a viewAnalysis) Doc() string  { return a.doc }
+
+func (a viewAnalysis) Run(in *AnalysisInput) (*Report, error) {
+	r := &Report{Views: make(map[*ssa.Function]interface{})}
+	for _, f := range in.Funcs {
+		if in.context().Err() != nil {
+			return nil, errDeadline
+		}
+		r.Views[f] = a.view(in, f)
+	}
+	return r, nil
+}
+
+// annotations are the results of all analyses which were run, ready to be
+// put into the model.  Diagnostics at instructions are also kept as their
+// findings, diagnostics at blocks and functions as notes.
+type annotations struct {
+	diags    []Diagnostic
+	findings map[ssa.Instruction][]string
+	funcs    map[*ssa.Function][]string
+	blocks   map[*ssa.BasicBlock][]string
+	instrs   map[ssa.Instruction][]string
+	views    map[string]map[*ssa.Function]interface{}
+	pkgViews map[string]interface{}
+	in       *AnalysisInput
+}
+
+// runAnalyses runs the analyses one after the other on in.  It stops with
+// errDeadline once the context of in is done.
+func runAnalyses(as []Analysis, in *AnalysisInput) (*annotations, error) {
+	an := &annotations{
+		findings: make(map[ssa.Instruction][]string),
+		funcs:    make(map[*ssa.Function][]string),
+		blocks:   make(map[*ssa.BasicBlock][]string),
+		instrs:   make(map[ssa.Instruction][]string),
+		views:    make(map[string]map[*ssa.Function]interface{}),
+		pkgViews: make(map[string]interface{}),
+		in:       in,
+	}
+	for _, a := range as {
+		if in.context().Err() != nil {
+			return nil, errDeadline
+		}
+		r, err := a.Run(in)
+		if err == errDeadline {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", a.Name(), err)
+		}
+		if r.Views != nil {
+			an.views[a.Name()] = r.Views
+		}
+		if r.PkgView != nil {
+			an.pkgViews[a.Name()] = r.PkgView
+		}
+		for _, fd := range r.Diagnostics {
+			d := an.diagnostic(a.Name(), fd)
+			for _, rel := range fd.Related {
+				d.Related = append(d.Related, an.diagnostic(a.Name(), rel))
+			}
+			an.diags = append(an.diags, d)
+			if fd.Instr != nil {
+				an.findings[fd.Instr] = append(an.findings[fd.Instr], fd.Message)
+			} else {
+				an.note(fd, a.Name()+": "+fd.Message)
+			}
+		}
+		for _, fd := range r.Annotations {
+			an.note(fd, a.Name()+": "+fd.Message)
+		}
+	}
+	return an, nil
+}
+
+// fill completes the function, block and position of a finding.
+func (an *annotations) fill(fd Finding) Finding {
+	if fd.Instr != nil {
+		if fd.Block == nil {
+			fd.Block = fd.Instr.Block()
+		}
+		if fd.Func == nil {
+			fd.Func = fd.Instr.Parent()
+		}
+		if !fd.Pos.IsValid() {
+			fd.Pos = fd.Instr.Pos()
+		}
+	}
+	if fd.Func == nil && fd.Block != nil {
+		fd.Func = fd.Block.Parent()
+	}
+	if !fd.Pos.IsValid() && fd.Func != nil {
+		fd.Pos = fd.Func.Pos()
+	}
+	return fd
+}
+
+func (an *annotations) diagnostic(check string, fd Finding) Diagnostic {
+	fd = an.fill(fd)
+	d := Diagnostic{Check: check, Message: fd.Message}
+	if fd.Func != nil {
+		d.Func = fd.Func.RelString(an.in.Pkg.Pkg)
+		d.Pos = posString(fd.Func.Prog, fd.Pos)
+		if fd.Instr != nil {
+			d.ID = an.in.defUse(fd.Func).id(fd.Instr)
+		}
+	}
+	return d
+}
+
+// note attaches msg to the innermost place of a finding.
+func (an *annotations) note(fd Finding, msg string) {
+	fd = an.fill(fd)
+	switch {
+	case fd.Instr != nil:
+		an.instrs[fd.Instr] = append(an.instrs[fd.Instr], msg)
+	case fd.Block != nil:
+		an.blocks[fd.Block] = append(an.blocks[fd.Block], msg)
+	case fd.Func != nil:
+		an.funcs[fd.Func] = append(an.funcs[fd.Func], msg)
+	}
+}
+
+// view returns the view of the analysis name for f, or nil if it was not
+// run.
+func (an *annotations) view(name string, f *ssa.Function) interface{} {
+	return an.views[name][f]
+}
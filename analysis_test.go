@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSelectAnalyses(t *testing.T) {
+	var all []string
+	for _, a := range analysisList() {
+		all = append(all, a.Name)
+	}
+	tests := []struct {
+		run  string
+		want []string
+		err  bool
+	}{
+		{"", all, false},
+		{"none", nil, false},
+		{"nilness, taint", []string{"nilness", "taint"}, false},
+		{"liveness", []string{"liveness"}, false},
+		{"nilness,bogus", nil, true},
+	}
+	for _, tt := range tests {
+		as, err := selectAnalyses(tt.run)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.run, err)
+			continue
+		}
+		var got []string
+		for _, a := range as {
+			got = append(got, a.Name())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.run, got, tt.want)
+		}
+	}
+}
+
+func TestSetOptionsAnalyses(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		run     string
+		checked []string
+	}{
+		{"api default", url.Values{}, "", nil},
+		{"api list", url.Values{"run": {"nilness,loops"}}, "nilness,loops", []string{"loops", "nilness"}},
+		{"ui checked", url.Values{"analyses": {"posted"}, "analysis": {"taint", "escape"}}, "taint,escape", []string{"escape", "taint"}},
+		{"ui none checked", url.Values{"analyses": {"posted"}}, "none", []string{}},
+		{"api none", url.Values{"run": {"none"}}, "none", []string{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		o := newOptions()
+		setOptions(o, r)
+		if got := o["run"].(string); got != tt.run {
+			t.Errorf("%s: run %q, want %q", tt.name, got, tt.run)
+		}
+		checked := []string{}
+		for _, cb := range o["analyses"].([]Cb) {
+			if cb.Checked {
+				checked = append(checked, cb.Name)
+			}
+		}
+		sort.Strings(checked)
+		want := tt.checked
+		if want == nil {
+			want = []string{}
+			for _, a := range analysisList() {
+				want = append(want, a.Name)
+			}
+		}
+		if !reflect.DeepEqual(checked, want) {
+			t.Errorf("%s: checked %v, want %v", tt.name, checked, want)
+		}
+	}
+}
+
+func TestBuildSSAViews(t *testing.T) {
+	const src = `package main
+
+func f(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+`
+	tests := []struct {
+		run   string
+		views []string
+	}{
+		{"", []string{"concurrency", "constprop", "defer", "escape", "liveness", "loops", "metrics", "pointsto", "postdom"}},
+		{"none", nil},
+		{"nilness,loops", []string{"loops"}},
+		{"liveness,concurrency", []string{"concurrency", "liveness"}},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		o["ssabuild"] = "true"
+		o["run"] = tt.run
+		s, err := toSSA(context.Background(), o, strings.NewReader(src), "main.go", "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var views []string
+		for name := range s.Views {
+			views = append(views, name)
+		}
+		sort.Strings(views)
+		if !reflect.DeepEqual(views, tt.views) {
+			t.Errorf("%q: views %v, want %v", tt.run, views, tt.views)
+		}
+		var f Func
+		for _, fn := range s.Funcs {
+			if fn.Name == "f" {
+				f = fn
+			}
+		}
+		if got, want := len(f.Loops) > 0, s.Views["loops"]; got != want {
+			t.Errorf("%q: loops %v, want them %v", tt.run, f.Loops, want)
+		}
+		if got, want := f.Metrics.Blocks > 0, s.Views["metrics"]; got != want {
+			t.Errorf("%q: metrics %+v, want them %v", tt.run, f.Metrics, want)
+		}
+		var live bool
+		for _, b := range f.Blocks {
+			live = live || len(b.LiveIn) > 0
+		}
+		if want := s.Views["liveness"]; live != want {
+			t.Errorf("%q: live values %v, want them %v", tt.run, live, want)
+		}
+	}
+}
+
+// TestPointsToAnalysis checks that the points-to sets are only solved for
+// the analyses which need them.
+func TestPointsToAnalysis(t *testing.T) {
+	const src = `package main
+
+func f() *int {
+	return new(int)
+}
+`
+	tests := []struct {
+		run      string
+		solved   bool
+		pointsTo bool
+	}{
+		{"", true, true},
+		{"none", false, false},
+		{"nilness,loops", false, false},
+		{"pointsto", true, true},
+		{"taint", true, false},
+	}
+	for _, tt := range tests {
+		as, err := selectAnalyses(tt.run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := testPackage(t, src)
+		in := &AnalysisInput{Pkg: pkg, Funcs: pkgFuncs(pkg), Options: newOptions()}
+		if _, err := runAnalyses(as, in); err != nil {
+			t.Fatal(err)
+		}
+		if solved := in.pa != nil; solved != tt.solved {
+			t.Errorf("%q: solved %v, want %v", tt.run, solved, tt.solved)
+		}
+		o := newOptions()
+		o["run"] = tt.run
+		s, err := toSSA(context.Background(), o, strings.NewReader(src), "main.go", "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pointsTo bool
+		for _, f := range s.Funcs {
+			for _, b := range f.Blocks {
+				for _, i := range b.Instrs {
+					pointsTo = pointsTo || len(i.PointsTo) > 0
+				}
+			}
+		}
+		if pointsTo != tt.pointsTo {
+			t.Errorf("%q: points-to sets shown %v, want %v", tt.run, pointsTo, tt.pointsTo)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// knownOS are the values of GOOS accepted by the go tool.
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true,
+	"illumos": true, "ios": true, "js": true, "linux": true, "netbsd": true,
+	"openbsd": true, "plan9": true, "solaris": true, "wasip1": true, "windows": true, "zos": true,
+}
+
+// Target is the platform a source is built for.  Empty fields keep the
+// value of the server.
+type Target struct {
+	GOOS   string
+	GOARCH string
+	Tags   string // space separated, quoted as for go build -tags
+	Cgo    string // CGO_ENABLED: 0, 1 or empty
+}
+
+// target returns the target given by the options.
+func (o options) target() Target {
+	return Target{o["goos"].(string), o["goarch"].(string), o["tags"].(string), o["cgo"].(string)}
+}
+
+// context returns the build context and the sizes of the types of the
+// target.  Like the go tool, cgo is disabled by default when building for
+// another platform.
+func (t Target) context() (*build.Context, types.Sizes, error) {
+	ctxt := build.Default
+	if t.GOOS != "" {
+		if !knownOS[t.GOOS] {
+			return nil, nil, fmt.Errorf("unknown GOOS %q", t.GOOS)
+		}
+		ctxt.GOOS = t.GOOS
+	}
+	if t.GOARCH != "" && t.GOARCH != ctxt.GOARCH {
+		ctxt.GOARCH = t.GOARCH
+		ctxt.ToolTags = toolTags(ctxt.GOARCH)
+	}
+	sizes := types.SizesFor(ctxt.Compiler, ctxt.GOARCH)
+	if sizes == nil {
+		return nil, nil, fmt.Errorf("unknown GOARCH %q", ctxt.GOARCH)
+	}
+	var tags buildutil.TagsFlag
+	if err := tags.Set(t.Tags); err != nil {
+		return nil, nil, fmt.Errorf("bad build tags: %v", err)
+	}
+	ctxt.BuildTags = tags
+	switch t.Cgo {
+	case "":
+		ctxt.CgoEnabled = build.Default.CgoEnabled && ctxt.GOOS == build.Default.GOOS && ctxt.GOARCH == build.Default.GOARCH
+	case "0":
+		ctxt.CgoEnabled = false
+	case "1":
+		ctxt.CgoEnabled = true
+	default:
+		return nil, nil, fmt.Errorf("bad CGO_ENABLED %q, want 0 or 1", t.Cgo)
+	}
+	return &ctxt, sizes, nil
+}
+
+// regabiArchs are the architectures using the register based calling
+// convention, whose files the runtime selects by goexperiment tags.
+var regabiArchs = map[string]bool{
+	"amd64": true, "arm64": true, "loong64": true, "ppc64": true, "ppc64le": true, "riscv64": true, "s390x": true,
+}
+
+// toolTags returns the tool tags of the server for another architecture:
+// the feature level tags of the server, e.g. amd64.v1, are dropped, and
+// so are the register ABI experiments if arch does not support them.
+func toolTags(arch string) []string {
+	var tags []string
+	for _, t := range build.Default.ToolTags {
+		if strings.HasPrefix(t, build.Default.GOARCH+".") ||
+			strings.HasPrefix(t, "goexperiment.regabi") && !regabiArchs[arch] {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+var (
+	pkgsOnce sync.Once
+	pkgs     []string
+)
+
+// allPackages returns the import paths of the packages in GOROOT and
+// GOPATH.  The list is kept, since walking them takes a while.
+func allPackages() []string {
+	pkgsOnce.Do(func() { pkgs = buildutil.AllPackages(&build.Default) })
+	return pkgs
+}
+
+// pkgFiles returns the directory of the package with the import path and
+// its Go files built in ctxt.
+func pkgFiles(ctxt *build.Context, path string) (string, []string, error) {
+	bp, err := ctxt.Import(path, "", 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return bp.Dir, append(append([]string(nil), bp.GoFiles...), bp.CgoFiles...), nil
+}
+
+// overlay returns ctxt with the file of the package with the import path
+// replaced by src.
+func overlay(ctxt *build.Context, path, file string, src []byte) (*build.Context, error) {
+	dir, files, err := pkgFiles(ctxt, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f == file {
+			return buildutil.OverlayContext(ctxt, map[string][]byte{filepath.Join(dir, f): src}), nil
+		}
+	}
+	return nil, fmt.Errorf("package %s has no file %s, want one of %s", path, file, strings.Join(files, ", "))
+}
+
+// matchFile reports whether the file name with the content src is built
+// in ctxt, judged by its name and build constraints.
+func matchFile(ctxt *build.Context, name string, src []byte) (bool, error) {
+	c := *ctxt
+	c.OpenFile = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(src)), nil
+	}
+	return c.MatchFile(".", name)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTargetContext(t *testing.T) {
+	tests := []struct {
+		target   Target
+		goos     string
+		goarch   string
+		tags     []string
+		cgo      bool
+		wordSize int64
+		err      string
+	}{
+		{Target{}, build.Default.GOOS, build.Default.GOARCH, nil, build.Default.CgoEnabled, 0, ""},
+		{Target{GOOS: "windows", GOARCH: "386"}, "windows", "386", nil, false, 4, ""},
+		{Target{GOARCH: "arm64", Tags: `a "b c"`}, build.Default.GOOS, "arm64", []string{"a", "b c"}, false, 8, ""},
+		{Target{GOOS: "linux", GOARCH: "arm", Cgo: "1"}, "linux", "arm", nil, true, 4, ""},
+		{Target{Cgo: "0"}, build.Default.GOOS, build.Default.GOARCH, nil, false, 0, ""},
+		{Target{GOOS: "mars"}, "", "", nil, false, 0, `unknown GOOS "mars"`},
+		{Target{GOARCH: "z80"}, "", "", nil, false, 0, `unknown GOARCH "z80"`},
+		{Target{Cgo: "yes"}, "", "", nil, false, 0, `bad CGO_ENABLED "yes", want 0 or 1`},
+		{Target{Tags: `"a`}, "", "", nil, false, 0, "bad build tags"},
+	}
+	for _, tt := range tests {
+		ctxt, sizes, err := tt.target.context()
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%+v: error %v, want %s", tt.target, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", tt.target, err)
+			continue
+		}
+		if ctxt.GOOS != tt.goos || ctxt.GOARCH != tt.goarch || ctxt.CgoEnabled != tt.cgo {
+			t.Errorf("%+v: got %s/%s cgo %v, want %s/%s cgo %v", tt.target, ctxt.GOOS, ctxt.GOARCH, ctxt.CgoEnabled, tt.goos, tt.goarch, tt.cgo)
+		}
+		if len(ctxt.BuildTags) > 0 || len(tt.tags) > 0 {
+			if !reflect.DeepEqual(ctxt.BuildTags, tt.tags) {
+				t.Errorf("%+v: tags %q, want %q", tt.target, ctxt.BuildTags, tt.tags)
+			}
+		}
+		if tt.wordSize != 0 {
+			if n := sizes.Sizeof(types.Typ[types.Int]); n != tt.wordSize {
+				t.Errorf("%+v: int of %d bytes, want %d", tt.target, n, tt.wordSize)
+			}
+		}
+	}
+}
+
+func TestToolTags(t *testing.T) {
+	for _, arch := range []string{"386", "arm64", build.Default.GOARCH} {
+		for _, tag := range toolTags(arch) {
+			if strings.HasPrefix(tag, build.Default.GOARCH+".") {
+				t.Errorf("%s: feature level tag %s of the server", arch, tag)
+			}
+			if strings.HasPrefix(tag, "goexperiment.regabi") && !regabiArchs[arch] {
+				t.Errorf("%s: register ABI tag %s", arch, tag)
+			}
+		}
+	}
+}
+
+func TestMatchFile(t *testing.T) {
+	ctxt, _, err := Target{GOOS: "linux", GOARCH: "amd64", Tags: "extra"}.context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, src string
+		want      bool
+	}{
+		{"a.go", "package main\n", true},
+		{"a_linux.go", "package main\n", true},
+		{"a_windows.go", "package main\n", false},
+		{"a_linux_arm.go", "package main\n", false},
+		{"a.go", "//go:build ignore\n\npackage main\n", false},
+		{"a.go", "//go:build linux && amd64\n\npackage main\n", true},
+		{"a.go", "// +build !linux\n\npackage main\n", false},
+		{"a.go", "//go:build extra\n\npackage main\n", true},
+		{"a.go", "//go:build other\n\npackage main\n", false},
+	}
+	for _, tt := range tests {
+		got, err := matchFile(ctxt, tt.name, []byte(tt.src))
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.name, tt.src, err)
+		} else if got != tt.want {
+			t.Errorf("%s %q: got %v, want %v", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+// TestTargetBuild checks that the files and the sizes of the target are
+// used by a build.
+func TestTargetBuild(t *testing.T) {
+	const src = `-- main.go --
+package main
+
+var big int = 1 << 40
+
+func main() {}
+-- a_amd64.go --
+package main
+
+func amd64() {}
+-- a_386.go --
+package main
+
+func i386() {}
+`
+	tests := []struct {
+		goarch, fn, err string
+	}{
+		{"amd64", "amd64", ""},
+		{"386", "", "main.go:3:15: cannot use 1 << 40"},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		o["goos"], o["goarch"] = "linux", tt.goarch
+		s, err := toSSA(context.Background(), o, strings.NewReader(src), "main.go", "main")
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %s", tt.goarch, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.goarch, err)
+			continue
+		}
+		var fs []string
+		for _, f := range s.Funcs {
+			if f.Name != "init" && f.Name != "main" {
+				fs = append(fs, f.Name)
+			}
+		}
+		if !reflect.DeepEqual(fs, []string{tt.fn}) {
+			t.Errorf("%s: functions %v, want %s", tt.goarch, fs, tt.fn)
+		}
+	}
+}
+
+// testGOPATH makes a GOPATH of the files, relative to its src directory,
+// the GOPATH of the default build context until the returned function is
+// called.
+func testGOPATH(t *testing.T, files map[string]string) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gopath := build.Default.GOPATH
+	build.Default.GOPATH = dir
+	return func() {
+		build.Default.GOPATH = gopath
+		os.RemoveAll(dir)
+	}
+}
+
+// testLib is a package without imports, so that it builds in any universe.
+var testLib = map[string]string{
+	"example.com/lib/lib.go": `package lib
+
+// Sum returns the sum of xs.
+func Sum(xs []int) int {
+	n := 0
+	for _, x := range xs {
+		n += x
+	}
+	return n
+}
+`,
+	"example.com/lib/lib_windows.go": `package lib
+
+func windows() {}
+`,
+}
+
+func TestImportSSA(t *testing.T) {
+	defer testGOPATH(t, testLib)()
+	tests := []struct {
+		path string
+		goos string
+		fns  []string
+		err  string
+	}{
+		{"example.com/lib", "linux", []string{"Sum", "init"}, ""},
+		{"example.com/lib", "windows", []string{"Sum", "init", "windows"}, ""},
+		{"no/such/package", "", nil, `cannot find package "no/such/package"`},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		o["ssabuild"] = "true"
+		o["goos"] = tt.goos
+		s, err := importSSA(context.Background(), o, tt.path, "", nil)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %s", tt.path, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		var fns []string
+		for _, f := range s.Funcs {
+			fns = append(fns, f.Name)
+		}
+		sort.Strings(fns)
+		if !reflect.DeepEqual(fns, tt.fns) {
+			t.Errorf("%s on %s: functions %v, want %v", tt.path, tt.goos, fns, tt.fns)
+		}
+	}
+}
+
+func TestPkgFiles(t *testing.T) {
+	defer testGOPATH(t, testLib)()
+	ctxt, _, err := Target{GOOS: "windows"}.context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, files, err := pkgFiles(ctxt, "example.com/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(dir) != "lib" || !reflect.DeepEqual(files, []string{"lib.go", "lib_windows.go"}) {
+		t.Errorf("got %s %v", dir, files)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	defer testGOPATH(t, testLib)()
+	edited := testLib["example.com/lib/lib.go"] + "\nfunc Edited() {}\n"
+	tests := []struct {
+		file, src string
+		fns       []string
+		err       string
+	}{
+		{"lib.go", edited, []string{"Edited", "Sum", "init"}, ""},
+		{"lib.go", "package lib\n\nfunc Sum(xs []int) int { return len(xs) }\n", []string{"Sum", "init"}, ""},
+		{"lib.go", "package lib\n\nfunc Sum( {}\n", nil, "lib.go:3:11"},
+		{"other.go", edited, nil, "package example.com/lib has no file other.go, want one of lib.go"},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		o["ssabuild"] = "true"
+		s, err := importSSA(context.Background(), o, "example.com/lib", tt.file, []byte(tt.src))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %s", tt.file, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		var fns []string
+		for _, f := range s.Funcs {
+			fns = append(fns, f.Name)
+		}
+		sort.Strings(fns)
+		if !reflect.DeepEqual(fns, tt.fns) {
+			t.Errorf("%s: functions %v, want %v", tt.file, fns, tt.fns)
+		}
+	}
+	// The file on disk is left alone.
+	ctxt, _, _ := Target{}.context()
+	dir, _, err := pkgFiles(ctxt, "example.com/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "lib.go")); string(b) != testLib["example.com/lib/lib.go"] {
+		t.Errorf("lib.go changed on disk:\n%s", b)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// buildable reports whether the builder supports the types of a package
+// with the type information info.  The builder predates generics and type
+// aliases and panics on them; if it does so while computing the method
+// sets of a type, it keeps a lock of the program held and any later build
+// in the program blocks.  So the packages whose types reach a type
+// parameter, an instance of a generic type or an alias are not built.
+func buildable(info *types.Info) bool {
+	seen := make(map[*types.Named]bool)
+	for _, tv := range info.Types {
+		if unsupported(tv.Type, seen) {
+			return false
+		}
+	}
+	for _, objs := range []map[*ast.Ident]types.Object{info.Defs, info.Uses} {
+		for _, obj := range objs {
+			if obj != nil && unsupported(obj.Type(), seen) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unsupported reports whether the builder panics on t or on a type it
+// reaches when computing the method sets of t: the types of its elements,
+// fields and methods.  seen are the named types already visited.
+func unsupported(t types.Type, seen map[*types.Named]bool) bool {
+	switch t := t.(type) {
+	case nil, *types.Basic:
+		return false
+	case *types.Named:
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		if t.TypeParams().Len() > 0 || t.TypeArgs().Len() > 0 {
+			return true
+		}
+		ms := types.NewMethodSet(types.NewPointer(t))
+		for k := 0; k < ms.Len(); k++ {
+			if unsupported(ms.At(k).Type(), seen) {
+				return true
+			}
+		}
+		return unsupported(t.Underlying(), seen)
+	case *types.Pointer:
+		return unsupported(t.Elem(), seen)
+	case *types.Slice:
+		return unsupported(t.Elem(), seen)
+	case *types.Array:
+		return unsupported(t.Elem(), seen)
+	case *types.Chan:
+		return unsupported(t.Elem(), seen)
+	case *types.Map:
+		return unsupported(t.Key(), seen) || unsupported(t.Elem(), seen)
+	case *types.Signature:
+		return t.TypeParams().Len() > 0 || unsupported(t.Params(), seen) || unsupported(t.Results(), seen)
+	case *types.Tuple:
+		for k := 0; k < t.Len(); k++ {
+			if unsupported(t.At(k).Type(), seen) {
+				return true
+			}
+		}
+		return false
+	case *types.Struct:
+		for k := 0; k < t.NumFields(); k++ {
+			if unsupported(t.Field(k).Type(), seen) {
+				return true
+			}
+		}
+		return false
+	case *types.Interface:
+		if !t.IsMethodSet() {
+			return true // a constraint
+		}
+		for k := 0; k < t.NumMethods(); k++ {
+			if unsupported(t.Method(k).Type(), seen) {
+				return true
+			}
+		}
+		return false
+	}
+	// Aliases, type parameters and unions.
+	return true
+}
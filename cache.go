@@ -0,0 +1,497 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/ssa"
+)
+
+// deps caches the type-checked dependencies of the builds and their SSA
+// form, so that a build only type-checks and builds the package it
+// renders.  Its size is bounded by the bytes of the parsed sources, see
+// loadLimits.
+var deps = &depCache{universes: make(map[string]*universe)}
+
+// depCache holds a universe of packages per build context.  Once the
+// sources of the dependencies exceed the limit, counted once for their
+// types and once for every program they are built in, the least recently
+// used universes are dropped as a whole: a package is never evicted alone,
+// since the packages importing it would then refer to types of another
+// copy.  Builds holding a dropped universe finish with it.
+type depCache struct {
+	mu        sync.Mutex
+	limit     int64 // CACHE_BYTES: bytes of parsed sources
+	userLimit int64 // CACHE_USER_BYTES: bytes of the sources of builds per universe
+	size      int64
+	tick      int64
+	universes map[string]*universe
+	hits      int64
+	misses    int64
+	built     int64
+	reused    int64
+	evictions int64
+}
+
+// CacheStats are the counters of the dependency cache.
+type CacheStats struct {
+	Hits      int64 // imports of a cached package
+	Misses    int64 // packages type-checked
+	Built     int64 // packages built to SSA
+	Reused    int64 // packages found built in a cached program
+	Evictions int64 // universes dropped
+	Universes int
+	Packages  int
+	Programs  int // idle SSA programs
+	Bytes     int64
+}
+
+// universe is a consistent set of type-checked packages of one build
+// context, with the SSA programs they are built in.  Its files and those
+// of the builds using it share its FileSet, so the sources of the builds
+// are counted as well: once they exceed the limit, the universe is
+// replaced by a new one.
+type universe struct {
+	key   string
+	ctxt  *build.Context
+	sizes types.Sizes
+	fset  *token.FileSet
+	used  int64
+	size  int64
+	user  int64                          // bytes of the sources of builds
+	pkgs  map[string]*depPkg             // by import path
+	paths map[string]string              // import path by directory and import
+	progs map[ssa.BuilderMode][]*depProg // idle programs by mode
+}
+
+// depProg is an SSA program of the dependencies of a universe.  It is lent
+// to one build at a time, since the builder does not support concurrent
+// builds in a program; the build creates its package in it together with
+// the dependencies the program lacks.  So every dependency is built once
+// per program.  size is the bytes of the sources of the dependencies
+// built.  A program is dropped after a failed build, see release.
+type depProg struct {
+	prog    *ssa.Program
+	created map[*depPkg]bool
+	size    int64
+	failed  bool // a dependency failed to build
+}
+
+// depPkg is a type-checked package.  Its functions are built if bodies is
+// set: the bodies of packages importing C are not type-checked, and the
+// builder does not support all packages, see buildable; a package it
+// failed on is not built again.  size is the bytes of its sources.  done
+// is closed once the package is loaded or has failed.
+type depPkg struct {
+	done    chan struct{}
+	err     error
+	pkg     *types.Package
+	files   []*ast.File
+	info    *types.Info
+	imports []*depPkg
+	bodies  bool // guarded by deps.mu once done
+	size    int64
+}
+
+// contextKey identifies the packages built in ctxt.
+func contextKey(ctxt *build.Context) string {
+	tags := append([]string(nil), ctxt.BuildTags...)
+	sort.Strings(tags)
+	return fmt.Sprint(ctxt.GOOS, "/", ctxt.GOARCH, " cgo=", ctxt.CgoEnabled, " tags=", tags, " tool=", ctxt.ToolTags)
+}
+
+// universe returns the universe of ctxt, creating it if there is none.
+func (c *depCache) universe(ctxt *build.Context, sizes types.Sizes) *universe {
+	key := contextKey(ctxt)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tick++
+	u := c.universes[key]
+	if u == nil {
+		u = &universe{key: key, ctxt: ctxt, sizes: sizes, fset: token.NewFileSet(),
+			pkgs: make(map[string]*depPkg), paths: make(map[string]string),
+			progs: make(map[ssa.BuilderMode][]*depProg)}
+		c.universes[key] = u
+	}
+	u.used = c.tick
+	return u
+}
+
+// grow accounts n bytes of the sources of dependencies in u and drops the
+// least recently used universes while the cache is over its limit.
+func (c *depCache) grow(u *universe, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.growLocked(u, n)
+}
+
+// growLocked is grow with c.mu held.
+func (c *depCache) growLocked(u *universe, n int64) {
+	u.size += n
+	if c.universes[u.key] != u {
+		return
+	}
+	c.size += n
+	for c.limit > 0 && c.size > c.limit && len(c.universes) > 0 {
+		var old *universe
+		for _, v := range c.universes {
+			if old == nil || v.used < old.used {
+				old = v
+			}
+		}
+		c.drop(old)
+	}
+}
+
+// addUser accounts n bytes of the sources of a build parsed into u.  They
+// stay in the FileSet of u and their packages in its programs, so once
+// they exceed the limit, u is dropped and the next build starts a new
+// universe.
+func (c *depCache) addUser(u *universe, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u.user += n
+	if c.userLimit > 0 && u.user > c.userLimit && c.universes[u.key] == u {
+		c.drop(u)
+	}
+}
+
+// drop removes the universe u from the cache; c.mu is held.
+func (c *depCache) drop(u *universe) {
+	delete(c.universes, u.key)
+	c.size -= u.size
+	c.evictions++
+}
+
+// stats returns the counters of the cache.
+func (c *depCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{Hits: c.hits, Misses: c.misses, Built: c.built, Reused: c.reused, Evictions: c.evictions, Universes: len(c.universes), Bytes: c.size}
+	for _, u := range c.universes {
+		s.Packages += len(u.pkgs)
+		for _, ps := range u.progs {
+			s.Programs += len(ps)
+		}
+	}
+	return s
+}
+
+var errForbidden = errors.New("clearing the cache needs the CACHE_TOKEN of the server")
+
+// cacheHandler reports the counters of the dependency and result caches.
+// DELETE, authorized by the CACHE_TOKEN of the server, clears the result
+// cache first.
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "DELETE" {
+		if !results.authorized(r) {
+			writeJSON(w, errForbidden)
+			return
+		}
+		if err := results.clear(); err != nil {
+			writeJSON(w, err)
+			return
+		}
+	}
+	writeJSON(w, struct {
+		Deps    CacheStats
+		Results ResultStats
+	}{deps.stats(), results.stats()})
+}
+
+// parseFile parses the file name of a build with the content src into the
+// FileSet of u.
+func (u *universe) parseFile(name string, src []byte) (*ast.File, error) {
+	deps.addUser(u, int64(len(src)))
+	return parser.ParseFile(u.fset, name, src, 0)
+}
+
+// parsePackage parses the Go files of bp, read through ctxt, into the
+// FileSet of u and returns them with their size in bytes.
+func (u *universe) parsePackage(ctxt *build.Context, bp *build.Package) ([]*ast.File, int64, error) {
+	var files []*ast.File
+	var n int64
+	for _, name := range append(append([]string(nil), bp.GoFiles...), bp.CgoFiles...) {
+		f, err := buildutil.ParseFile(u.fset, ctxt, nil, bp.Dir, name, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		files = append(files, f)
+		n += int64(u.fset.File(f.Pos()).Size())
+	}
+	return files, n, nil
+}
+
+// load returns the package imported by path from the directory dir,
+// type-checking it and its imports unless they are cached.  stack lists
+// the packages importing it, to report import cycles.  The guard limits
+// the packages of the build and stops loading at its deadline.
+func (u *universe) load(g *loadGuard, path, dir string, stack []string) (*depPkg, error) {
+	// Imports are resolved once per directory: a cached package is
+	// found without reading its directory again.
+	key := dir + "\x00" + path
+	deps.mu.Lock()
+	resolved, ok := u.paths[key]
+	deps.mu.Unlock()
+	var bp *build.Package
+	if !ok {
+		var err error
+		if bp, err = u.ctxt.Import(path, dir, 0); err != nil {
+			return nil, err
+		}
+		resolved = bp.ImportPath
+		deps.mu.Lock()
+		u.paths[key] = resolved
+		deps.mu.Unlock()
+	}
+	for _, s := range stack {
+		if s == resolved {
+			return nil, fmt.Errorf("import cycle: %s -> %s", strings.Join(stack, " -> "), resolved)
+		}
+	}
+	for {
+		if err := g.check(resolved); err != nil {
+			return nil, err
+		}
+		deps.mu.Lock()
+		p := u.pkgs[resolved]
+		if p == nil {
+			p = &depPkg{done: make(chan struct{})}
+			u.pkgs[resolved] = p
+			deps.misses++
+			deps.mu.Unlock()
+			return p, u.check(g, p, bp, resolved, append(stack, resolved))
+		}
+		deps.hits++
+		deps.mu.Unlock()
+		select {
+		case <-p.done:
+		case <-g.ctx.Done():
+			return nil, errDeadline
+		}
+		if p.err == nil {
+			return p, nil
+		}
+		// A failed load is not kept: try again, the error may have
+		// been a limit of another build.
+	}
+}
+
+// check type-checks the package with the import path into p; bp is the
+// package, if already found.  Files importing C are checked without running
+// cgo and so without the bodies of their functions.
+func (u *universe) check(g *loadGuard, p *depPkg, bp *build.Package, path string, stack []string) (err error) {
+	defer func() {
+		p.err = err
+		if err != nil {
+			deps.mu.Lock()
+			if u.pkgs[path] == p {
+				delete(u.pkgs, path)
+			}
+			deps.mu.Unlock()
+		}
+		close(p.done)
+	}()
+	if bp == nil {
+		if bp, err = u.ctxt.Import(path, "", 0); err != nil {
+			return err
+		}
+	}
+	files, size, err := u.parsePackage(u.ctxt, bp)
+	deps.grow(u, size)
+	if err != nil {
+		return err
+	}
+	imp := &depImporter{u: u, g: g, stack: stack}
+	var first error
+	conf := types.Config{
+		Importer:         imp,
+		Sizes:            u.sizes,
+		IgnoreFuncBodies: len(bp.CgoFiles) > 0,
+		FakeImportC:      len(bp.CgoFiles) > 0,
+		Error: func(err error) {
+			if first == nil {
+				first = err
+			}
+		},
+	}
+	info := newInfo()
+	pkg, _ := conf.Check(path, u.fset, files, info)
+	if g.err != nil {
+		return g.err
+	}
+	if first != nil {
+		return first
+	}
+	p.pkg, p.files, p.info, p.imports, p.size = pkg, files, info, imp.deps, size
+	p.bodies = len(bp.CgoFiles) == 0 && buildable(info)
+	return nil
+}
+
+// newInfo returns the type information recorded for the SSA builder.
+func newInfo() *types.Info {
+	return &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+}
+
+// depImporter imports the packages of the universe, recording them.
+type depImporter struct {
+	u     *universe
+	g     *loadGuard
+	stack []string
+	deps  []*depPkg
+}
+
+func (i *depImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, "", 0)
+}
+
+func (i *depImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	p, err := i.u.load(i.g, path, dir, i.stack)
+	if err != nil {
+		return nil, err
+	}
+	i.deps = append(i.deps, p)
+	return p.pkg, nil
+}
+
+// create type-checks the files of the package path and returns its SSA
+// package in a program of u holding its dependencies, which are built as
+// far as the builder supports them.  It reports at most 10 type errors.
+// The program is lent to the caller until it calls done with the error of
+// its build.
+func (u *universe) create(g *loadGuard, o options, path string, files []*ast.File) (pkg *ssa.Package, done func(error), err error) {
+	imp := &depImporter{u: u, g: g}
+	var errs []string
+	conf := types.Config{
+		Importer: imp,
+		Sizes:    u.sizes,
+		Error: func(err error) {
+			if len(errs) < 10 {
+				errs = append(errs, err.Error())
+			}
+		},
+	}
+	info := newInfo()
+	tpkg, _ := conf.Check(path, u.fset, files, info)
+	if g.err != nil {
+		return nil, nil, g.err
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.New(strings.Join(errs, "\n"))
+	}
+	mode := ssa.NaiveForm
+	if o["ssabuild"] == "true" {
+		mode = ssa.SanityCheckFunctions
+	}
+	dp := u.program(mode)
+	done = func(err error) { u.release(dp, mode, err) }
+	defer func() {
+		if err != nil {
+			done(err)
+		}
+	}()
+	// All dependencies count for the package limit, also those the
+	// program holds already.
+	var added []*depPkg
+	seen := make(map[*depPkg]bool)
+	var add func(p *depPkg) error
+	add = func(p *depPkg) error {
+		if seen[p] {
+			return nil
+		}
+		seen[p] = true
+		if err := g.check(p.pkg.Path()); err != nil {
+			return err
+		}
+		if !dp.created[p] {
+			dp.created[p] = true
+			dp.prog.CreatePackage(p.pkg, p.files, p.info, true)
+			added = append(added, p)
+		}
+		for _, q := range p.imports {
+			if err := add(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, p := range imp.deps {
+		if err := add(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	pkg = dp.prog.CreatePackage(tpkg, files, info, false)
+	deps.mu.Lock()
+	deps.reused += int64(len(seen) - len(added))
+	deps.mu.Unlock()
+	for _, p := range added {
+		deps.mu.Lock()
+		bodies := p.bodies
+		deps.mu.Unlock()
+		if !bodies {
+			continue
+		}
+		if err := g.check(p.pkg.Path()); err != nil {
+			return nil, nil, err
+		}
+		ok := buildDep(dp.prog.Package(p.pkg))
+		deps.mu.Lock()
+		deps.built++
+		if !ok {
+			p.bodies = false
+			dp.failed = true
+		}
+		dp.size += p.size
+		deps.growLocked(u, p.size)
+		deps.mu.Unlock()
+	}
+	return pkg, done, nil
+}
+
+// program lends an idle program of u with the mode to a build, or a new
+// one if there is none.
+func (u *universe) program(mode ssa.BuilderMode) *depProg {
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+	if ps := u.progs[mode]; len(ps) > 0 {
+		dp := ps[len(ps)-1]
+		u.progs[mode] = ps[:len(ps)-1]
+		return dp
+	}
+	prog := ssa.NewProgram(u.fset, mode)
+	createUnsafe(prog)
+	return &depProg{prog: prog, created: make(map[*depPkg]bool)}
+}
+
+// release takes back the program lent to a build which ended with err.
+// The program is dropped if the build failed or a dependency failed to
+// build: the builder may have panicked and left it inconsistent.
+func (u *universe) release(dp *depProg, mode ssa.BuilderMode, err error) {
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+	if err == nil && !dp.failed {
+		u.progs[mode] = append(u.progs[mode], dp)
+		return
+	}
+	deps.growLocked(u, -dp.size)
+}
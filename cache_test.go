@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go/build"
+	"go/types"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// testDeps replaces the dependency cache by an empty one with the limits
+// until the returned function is called.
+func testDeps(limit, userLimit int64) func() {
+	old := deps
+	deps = &depCache{universes: make(map[string]*universe), limit: limit, userLimit: userLimit}
+	return func() { deps = old }
+}
+
+// TestDepPrograms checks that the dependencies are built once per program
+// and that the sources of the builds do not count for CACHE_BYTES.
+func TestDepPrograms(t *testing.T) {
+	defer testGOPATH(t, testLib)()
+	defer testDeps(0, 0)()
+	lib := int64(len(testLib["example.com/lib/lib.go"]))
+	tests := []struct {
+		src      string
+		built    int64
+		reused   int64
+		programs int
+	}{
+		{"package main\n\nimport \"example.com/lib\"\n\nvar n = lib.Sum(nil)\n", 1, 0, 1},
+		{"package main\n\nimport \"example.com/lib\"\n\nvar m = lib.Sum([]int{1})\n", 1, 1, 1},
+		{"package main\n\nfunc main() {}\n", 1, 1, 1},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		o["run"] = "none"
+		if _, err := toSSA(context.Background(), o, strings.NewReader(tt.src), "main.go", "main"); err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		s := deps.stats()
+		if s.Built != tt.built || s.Reused != tt.reused || s.Programs != tt.programs {
+			t.Errorf("%q: built %d, reused %d, programs %d, want %d, %d, %d", tt.src, s.Built, s.Reused, s.Programs, tt.built, tt.reused, tt.programs)
+		}
+		// The package counts once for its types and once for the
+		// program it is built in.
+		if s.Bytes != 2*lib {
+			t.Errorf("%q: %d bytes, want %d", tt.src, s.Bytes, 2*lib)
+		}
+	}
+}
+
+func TestDepGrow(t *testing.T) {
+	tests := []struct {
+		name  string
+		grow  []int64 // bytes added to the universes a, b and a
+		left  []string
+		bytes int64
+	}{
+		{"under limit", []int64{40, 40, 10}, []string{"a", "b"}, 90},
+		{"evicts oldest", []int64{40, 40, 30}, []string{"b"}, 40},
+		{"evicts the grown", []int64{40, 10, 90}, []string{"b"}, 10},
+	}
+	for _, tt := range tests {
+		func() {
+			defer testDeps(100, 0)()
+			ua := deps.universe(&build.Context{GOOS: "a"}, types.SizesFor("gc", "amd64"))
+			ub := deps.universe(&build.Context{GOOS: "b"}, types.SizesFor("gc", "amd64"))
+			for k, u := range []*universe{ua, ub, ua} {
+				deps.grow(u, tt.grow[k])
+			}
+			var left []string
+			for _, u := range []*universe{ua, ub} {
+				if deps.universes[u.key] == u {
+					left = append(left, u.ctxt.GOOS)
+				}
+			}
+			if strings.Join(left, " ") != strings.Join(tt.left, " ") || deps.size != tt.bytes {
+				t.Errorf("%s: universes %v of %d bytes, want %v of %d", tt.name, left, deps.size, tt.left, tt.bytes)
+			}
+		}()
+	}
+}
+
+func TestAddUser(t *testing.T) {
+	tests := []struct {
+		limit   int64
+		add     []int64
+		dropped bool
+	}{
+		{0, []int64{1 << 30}, false},
+		{100, []int64{50, 50}, false},
+		{100, []int64{50, 51}, true},
+	}
+	for _, tt := range tests {
+		func() {
+			defer testDeps(0, tt.limit)()
+			u := deps.universe(&build.Context{GOOS: "a"}, types.SizesFor("gc", "amd64"))
+			deps.grow(u, 10)
+			for _, n := range tt.add {
+				deps.addUser(u, n)
+			}
+			if dropped := deps.universes[u.key] != u; dropped != tt.dropped {
+				t.Errorf("%d %v: dropped %v, want %v", tt.limit, tt.add, dropped, tt.dropped)
+			}
+			if tt.dropped && deps.size != 0 {
+				t.Errorf("%d %v: %d bytes left", tt.limit, tt.add, deps.size)
+			}
+			if s := deps.stats(); s.Bytes != deps.size {
+				t.Errorf("%d %v: stats of %d bytes, want %d", tt.limit, tt.add, s.Bytes, deps.size)
+			}
+		}()
+	}
+}
+
+func TestRelease(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		failed bool
+		kept   bool
+	}{
+		{"ok", nil, false, true},
+		{"build error", errors.New("boom"), false, false},
+		{"dependency failed", nil, true, false},
+	}
+	for _, tt := range tests {
+		func() {
+			defer testDeps(0, 0)()
+			u := deps.universe(&build.Context{GOOS: "a"}, types.SizesFor("gc", "amd64"))
+			dp := u.program(ssa.NaiveForm)
+			dp.size, dp.failed = 30, tt.failed
+			deps.grow(u, 50)
+			u.release(dp, ssa.NaiveForm, tt.err)
+			kept := len(u.progs[ssa.NaiveForm]) == 1
+			size := int64(50)
+			if !tt.kept {
+				size = 20
+			}
+			if kept != tt.kept || deps.size != size {
+				t.Errorf("%s: kept %v with %d bytes, want %v with %d", tt.name, kept, deps.size, tt.kept, size)
+			}
+			if tt.kept && u.program(ssa.NaiveForm) != dp {
+				t.Errorf("%s: program not lent again", tt.name)
+			}
+		}()
+	}
+}
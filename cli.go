@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const usage = `usage: ssaview [command [flags] file.go|file.txtar|import path]
+
+Without a command ssaview serves the web interface.
+
+Commands:
+  check      print the diagnostics of all analyses or those given by -run
+  analyses   list the analyses
+  packages   list the packages in GOROOT and GOPATH
+  query      print the instructions matched by a query, e.g.
+             ssaview query 'call where callee matches "fmt.*"' file.go
+`
+
+// runCommand runs ssaview as a command line tool and returns the exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "check":
+		return check(args[1:])
+	case "query":
+		if len(args) < 2 {
+			break
+		}
+		return queryCmd(args[1], args[2:])
+	case "analyses":
+		for _, a := range analysisList() {
+			fmt.Printf("%-14s %s\n", a.Name, a.Doc)
+		}
+		return 0
+	case "packages":
+		for _, p := range allPackages() {
+			fmt.Println(p)
+		}
+		return 0
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+// parseSource parses the flags shared by all commands and builds the SSA
+// representation of the file or package given as the only argument.
+// Arguments not ending in .go or .txtar are import paths.
+func parseSource(o options, fs *flag.FlagSet, args []string) (SSA, error) {
+	naive := fs.Bool("naive", false, "build the SSA in the naive form without SanityCheckFunctions")
+	run := fs.String("run", "", "run the analyses of the comma separated `list` instead of all")
+	taint := fs.String("taint", "", "read the taint `spec` of sources, sinks and sanitizers from a file")
+	goos := fs.String("goos", "", "build for the operating `system` instead of $GOOS")
+	goarch := fs.String("goarch", "", "build for the `architecture` instead of $GOARCH")
+	tags := fs.String("tags", "", "space separated `list` of build tags")
+	cgo := fs.String("cgo", "", "CGO_ENABLED, 0 or 1; disabled by default for other platforms")
+	edit := fs.String("edit", "", "with an import path, replace the file `name=path` of the package by path")
+	funcs := fs.String("funcs", "", "only show the functions matching the `glob`")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	o["ssabuild"] = fmt.Sprint(!*naive)
+	o["run"] = *run
+	o["goos"], o["goarch"], o["tags"], o["cgo"] = *goos, *goarch, *tags, *cgo
+	o["funcs"] = *funcs
+	if *taint != "" {
+		spec, err := ioutil.ReadFile(*taint)
+		if err != nil {
+			return SSA{}, err
+		}
+		o["taint"] = string(spec)
+	}
+
+	file := fs.Arg(0)
+	if !strings.HasSuffix(file, ".go") && !strings.HasSuffix(file, ".txtar") {
+		if *edit == "" {
+			return importSSA(context.Background(), o, file, "", nil)
+		}
+		i := strings.Index(*edit, "=")
+		if i < 0 {
+			return SSA{}, fmt.Errorf("bad -edit %q, want name=path", *edit)
+		}
+		src, err := ioutil.ReadFile((*edit)[i+1:])
+		if err != nil {
+			return SSA{}, err
+		}
+		return importSSA(context.Background(), o, file, (*edit)[:i], src)
+	}
+	src, err := os.Open(file)
+	if err != nil {
+		return SSA{}, err
+	}
+	defer src.Close()
+	return toSSA(context.Background(), o, src, file, "main")
+}
+
+// check prints one diagnostic per line and fails if there is any.
+func check(args []string) int {
+	s, err := parseSource(newOptions(), flag.NewFlagSet("check", flag.ExitOnError), args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, d := range s.Diagnostics {
+		fmt.Printf("%s: %s: %s (%s)\n", d.Pos, d.Func, d.Message, d.Check)
+		for _, r := range d.Related {
+			fmt.Printf("\t%s: %s: %s\n", r.Pos, r.Func, r.Message)
+		}
+	}
+	if len(s.Diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// queryCmd prints one matched instruction per line and fails if there is
+// none.
+func queryCmd(q string, args []string) int {
+	o := newOptions()
+	o["query"] = q
+	s, err := parseSource(o, flag.NewFlagSet("query", flag.ExitOnError), args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, r := range s.Query {
+		fmt.Printf("%s: %s: block %d: %s\n", r.Pos, r.Func, r.Block, r.Instr)
+	}
+	if len(s.Query) == 0 {
+		return 1
+	}
+	return 0
+}
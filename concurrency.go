@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(concurrencyAnalysis{})
+}
+
+// concurrencyAnalysis computes the concurrency overview of the package.
+type concurrencyAnalysis struct{}
+
+func (concurrencyAnalysis) Name() string { return "concurrency" }
+func (concurrencyAnalysis) Doc() string {
+	return "show the goroutines, channels and channel operations of the package"
+}
+
+func (concurrencyAnalysis) Run(in *AnalysisInput) (*Report, error) {
+	return &Report{PkgView: concurrencyOverview(in.Pkg)}, nil
+}
+
+// Concurrency is a structural overview of the goroutines and channels of a
+// package.  Channels are identified by their MakeChan allocation site.
+type Concurrency struct {
+	Channels []Channel
+	Spawns   []Spawn
+	Ops      []ChanOp
+	Selects  []SelectOp
+	Dot      string
+}
+
+// Channel is a MakeChan allocation site.
+type Channel struct {
+	Name string
+	Func string
+	Type string
+	Size string
+	Pos  string
+}
+
+// Spawn is a go statement in From starting the function To.
+type Spawn struct {
+	From string
+	To   string
+	Pos  string
+}
+
+// ChanOp is a send, receive or close in Func on the given channels.
+type ChanOp struct {
+	Func     string
+	Op       string
+	Channels []string
+	Pos      string
+}
+
+// SelectOp is a select statement and the direction and channels of each
+// of its cases.
+type SelectOp struct {
+	Func     string
+	Blocking bool
+	Cases    []ChanOp
+	Pos      string
+}
+
+// chanFlow tracks which MakeChan sites every value may refer to with a
+// flow-insensitive propagation through Phi nodes, conversions, loads and
+// stores of local variables, call arguments and closure bindings.
+type chanFlow struct {
+	sites  map[ssa.Value]map[*ssa.MakeChan]bool
+	names  map[*ssa.MakeChan]string
+	stores map[ssa.Value][]ssa.Value
+	alias  map[ssa.Value]ssa.Value // free variables bound to addresses
+}
+
+// root returns the address a free variable is bound to.
+func (cf *chanFlow) root(v ssa.Value) ssa.Value {
+	for cf.alias[v] != nil {
+		v = cf.alias[v]
+	}
+	return v
+}
+
+func (cf *chanFlow) add(v ssa.Value, from ...ssa.Value) bool {
+	changed := false
+	for _, w := range from {
+		for s := range cf.sites[w] {
+			if cf.sites[v] == nil {
+				cf.sites[v] = make(map[*ssa.MakeChan]bool)
+			}
+			if !cf.sites[v][s] {
+				cf.sites[v][s] = true
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// channels returns the names of the allocation sites v may refer to.
+func (cf *chanFlow) channels(v ssa.Value) []string {
+	var ns []string
+	for s := range cf.sites[v] {
+		ns = append(ns, cf.names[s])
+	}
+	sort.Strings(ns)
+	if len(ns) == 0 {
+		ns = append(ns, "unknown channel "+v.Name())
+	}
+	return ns
+}
+
+// concurrencyOverview collects the go statements, channel allocations,
+// sends, receives, selects and calls of close in the functions of pkg.
+func concurrencyOverview(pkg *ssa.Package) Concurrency {
+	var c Concurrency
+	prog := pkg.Prog
+	funcs := pkgFuncs(pkg)
+	cf := &chanFlow{
+		sites:  make(map[ssa.Value]map[*ssa.MakeChan]bool),
+		names:  make(map[*ssa.MakeChan]string),
+		stores: make(map[ssa.Value][]ssa.Value),
+		alias:  make(map[ssa.Value]ssa.Value),
+	}
+
+	// Name the allocation sites and seed the propagation.
+	for _, f := range funcs {
+		for _, b := range f.Blocks {
+			for _, i := range b.Instrs {
+				switch i := i.(type) {
+				case *ssa.MakeChan:
+					fn := f.RelString(pkg.Pkg)
+					name := fn + "." + i.Name()
+					cf.names[i] = name
+					cf.sites[i] = map[*ssa.MakeChan]bool{i: true}
+					c.Channels = append(c.Channels, Channel{name, fn, i.Type().String(), i.Size.Name(), posString(prog, i.Pos())})
+				case *ssa.MakeClosure:
+					for k, fv := range i.Fn.(*ssa.Function).FreeVars {
+						if _, ok := i.Bindings[k].Type().Underlying().(*types.Pointer); ok {
+							cf.alias[fv] = i.Bindings[k]
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, f := range funcs {
+		for _, b := range f.Blocks {
+			for _, i := range b.Instrs {
+				if st, ok := i.(*ssa.Store); ok {
+					addr := cf.root(st.Addr)
+					cf.stores[addr] = append(cf.stores[addr], st.Val)
+				}
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, f := range funcs {
+			for _, b := range f.Blocks {
+				for _, i := range b.Instrs {
+					switch i := i.(type) {
+					case *ssa.Phi:
+						changed = cf.add(i, i.Edges...) || changed
+					case *ssa.ChangeType:
+						changed = cf.add(i, i.X) || changed
+					case *ssa.UnOp:
+						if i.Op == token.MUL {
+							changed = cf.add(i, cf.stores[cf.root(i.X)]...) || changed
+						}
+					case *ssa.MakeClosure:
+						fn := i.Fn.(*ssa.Function)
+						for k, fv := range fn.FreeVars {
+							changed = cf.add(fv, i.Bindings[k]) || changed
+						}
+					case ssa.CallInstruction:
+						callee := i.Common().StaticCallee()
+						if callee == nil || callee.Pkg != pkg {
+							continue
+						}
+						for k, p := range callee.Params {
+							if k < len(i.Common().Args) {
+								changed = cf.add(p, i.Common().Args[k]) || changed
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, f := range funcs {
+		name := f.RelString(pkg.Pkg)
+		for _, b := range f.Blocks {
+			for _, i := range b.Instrs {
+				pos := posString(prog, i.Pos())
+				switch i := i.(type) {
+				case *ssa.Go:
+					c.Spawns = append(c.Spawns, Spawn{name, calleeName(&i.Call, pkg.Pkg), pos})
+				case *ssa.Send:
+					c.Ops = append(c.Ops, ChanOp{name, "send", cf.channels(i.Chan), pos})
+				case *ssa.UnOp:
+					if i.Op == token.ARROW {
+						c.Ops = append(c.Ops, ChanOp{name, "receive", cf.channels(i.X), pos})
+					}
+				case *ssa.Call:
+					if bi, ok := i.Call.Value.(*ssa.Builtin); ok && bi.Name() == "close" {
+						c.Ops = append(c.Ops, ChanOp{name, "close", cf.channels(i.Call.Args[0]), pos})
+					}
+				case *ssa.Select:
+					s := SelectOp{name, i.Blocking, nil, pos}
+					for _, st := range i.States {
+						op := "receive"
+						if st.Dir == types.SendOnly {
+							op = "send"
+						}
+						s.Cases = append(s.Cases, ChanOp{name, op, cf.channels(st.Chan), posString(prog, st.Pos)})
+					}
+					c.Selects = append(c.Selects, s)
+				}
+			}
+		}
+	}
+	c.Dot = c.dot()
+	return c
+}
+
+// dot renders the overview as a Graphviz graph: functions are boxes,
+// channels ellipses.  Edges go from a function to the goroutines it
+// spawns and to the channels it sends on or closes, and from channels to
+// the functions receiving from them.
+func (c Concurrency) dot() string {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	buf.WriteString("digraph concurrency {\n")
+	for _, ch := range c.Channels {
+		fmt.Fprintf(&buf, "  %q [shape=ellipse label=%q];\n", ch.Name, ch.Name+"\n"+ch.Type)
+	}
+	fn := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			fmt.Fprintf(&buf, "  %q [shape=box];\n", name)
+		}
+	}
+	edge := func(from, to, label, style string) {
+		e := fmt.Sprintf("  %q -> %q [label=%q style=%s];\n", from, to, label, style)
+		if !seen[e] {
+			seen[e] = true
+			buf.WriteString(e)
+		}
+	}
+	for _, s := range c.Spawns {
+		fn(s.From)
+		fn(s.To)
+		edge(s.From, s.To, "go", "dashed")
+	}
+	ops := append([]ChanOp(nil), c.Ops...)
+	for _, s := range c.Selects {
+		ops = append(ops, s.Cases...)
+	}
+	for _, op := range ops {
+		fn(op.Func)
+		for _, ch := range op.Channels {
+			switch op.Op {
+			case "send":
+				edge(op.Func, ch, "send", "solid")
+			case "receive":
+				edge(ch, op.Func, "receive", "solid")
+			case "close":
+				edge(op.Func, ch, "close", "dotted")
+			}
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConcurrencyOverview(t *testing.T) {
+	src := `package main
+
+func worker(in <-chan int, out chan<- int) {
+	for x := range in {
+		out <- x
+	}
+	close(out)
+}
+
+func main() {
+	in := make(chan int)
+	out := make(chan int, 1)
+	done := make(chan bool)
+	go worker(in, out)
+	go func() {
+		in <- 1
+		close(in)
+		done <- true
+	}()
+	select {
+	case x := <-out:
+		println(x)
+	case <-done:
+	}
+}
+`
+	// in is captured by the closure and allocated first: t0.
+	c := concurrencyOverview(testPackage(t, src))
+	var got []string
+	for _, ch := range c.Channels {
+		got = append(got, fmt.Sprintf("make %s %s %s", ch.Name, ch.Type, ch.Size))
+	}
+	for _, s := range c.Spawns {
+		got = append(got, fmt.Sprintf("go %s -> %s", s.From, s.To))
+	}
+	for _, op := range c.Ops {
+		got = append(got, fmt.Sprintf("%s %s %s", op.Func, op.Op, strings.Join(op.Channels, ",")))
+	}
+	for _, s := range c.Selects {
+		for _, op := range s.Cases {
+			got = append(got, fmt.Sprintf("select %s %s %s", op.Func, op.Op, strings.Join(op.Channels, ",")))
+		}
+	}
+	want := []string{
+		"make main.t1 chan int 0:int",
+		"make main.t2 chan int 1:int",
+		"make main.t4 chan bool 0:int",
+		"go main -> worker",
+		"go main -> main$1",
+		"worker receive main.t1",
+		"worker send main.t2",
+		"worker close main.t2",
+		"main$1 send main.t1",
+		"main$1 close main.t1",
+		"main$1 send main.t4",
+		"select main receive main.t2",
+		"select main receive main.t4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, e := range []string{`"main" -> "worker" [label="go" style=dashed]`, `"main.t2" -> "main" [label="receive" style=solid]`} {
+		if !strings.Contains(c.Dot, e) {
+			t.Errorf("graph has no edge %s:\n%s", e, c.Dot)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"defer", "show the defer statements and which of them run on return and on panic", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return deferInfo(f, in.defUse(f), in.loopsOf(f))
+	}})
+}
+
+// DeferSite is a defer statement of a function.
+type DeferSite struct {
+	ID     string
+	Call   string
+	Block  int
+	Pos    string
+	InLoop bool
+}
+
+// DeferPoint is a RunDefers instruction or an instruction which may panic
+// together with the deferred calls which may run there, the last
+// registered first.
+type DeferPoint struct {
+	ID     string
+	Block  int
+	Pos    string
+	Defers []DeferSite
+}
+
+// DeferInfo explains the defer, panic and recover control flow of a
+// function.  Panics has the first instruction which may panic of every
+// run of them between two defer statements, if any call is deferred
+// there.  Recover is the index of the block control is transferred to
+// after a recovered panic or -1.
+type DeferInfo struct {
+	Defers    []DeferSite
+	RunDefers []DeferPoint
+	Panics    []DeferPoint
+	Recover   int
+}
+
+// deferInfo finds the Defer, RunDefers and Panic instructions of f and
+// the instructions which may panic.  A deferred call runs at a RunDefers
+// or a panic if its Defer may be executed before, which is found by a
+// forward data flow analysis of the defers registered at the start of
+// every block.
+func deferInfo(f *ssa.Function, du *defUse, li *loopInfo) DeferInfo {
+	d := DeferInfo{Recover: -1}
+	if f.Recover != nil {
+		d.Recover = f.Recover.Index
+	}
+	var defers []*ssa.Defer
+	index := make(map[*ssa.Defer]int)
+	for _, b := range f.Blocks {
+		for _, i := range b.Instrs {
+			if i, ok := i.(*ssa.Defer); ok {
+				index[i] = len(defers)
+				defers = append(defers, i)
+				d.Defers = append(d.Defers, deferSite(f, du, li, i))
+			}
+		}
+	}
+	if len(defers) == 0 {
+		return d
+	}
+
+	// in[b] holds the defers which may be registered at the start of b.
+	in := make(map[*ssa.BasicBlock][]bool)
+	for _, b := range f.Blocks {
+		in[b] = make([]bool, len(defers))
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			out := append([]bool(nil), in[b]...)
+			for _, i := range b.Instrs {
+				if i, ok := i.(*ssa.Defer); ok {
+					out[index[i]] = true
+				}
+			}
+			for _, s := range b.Succs {
+				for k, r := range out {
+					if r && !in[s][k] {
+						in[s][k] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		regs := append([]bool(nil), in[b]...)
+		point := func(i ssa.Instruction) DeferPoint {
+			dp := DeferPoint{du.id(i), b.Index, posString(f.Prog, i.Pos()), nil}
+			for k := len(defers) - 1; k >= 0; k-- {
+				if regs[k] {
+					dp.Defers = append(dp.Defers, deferSite(f, du, li, defers[k]))
+				}
+			}
+			return dp
+		}
+		run := false // a panic of the current run is recorded
+		for _, i := range b.Instrs {
+			switch i := i.(type) {
+			case *ssa.Defer:
+				regs[index[i]] = true
+				run = false
+			case *ssa.RunDefers:
+				d.RunDefers = append(d.RunDefers, point(i))
+			default:
+				if !run && mayPanic(i) {
+					run = true
+					if dp := point(i); len(dp.Defers) > 0 {
+						d.Panics = append(d.Panics, dp)
+					}
+				}
+			}
+		}
+	}
+	return d
+}
+
+func deferSite(f *ssa.Function, du *defUse, li *loopInfo, i *ssa.Defer) DeferSite {
+	return DeferSite{du.id(i), calleeName(&i.Call, f.Pkg.Pkg), i.Block().Index, posString(f.Prog, i.Pos()), li.depth[i.Block()] > 0}
+}
+
+// mayPanic reports whether i may panic: explicitly, in a call or at run
+// time.
+func mayPanic(i ssa.Instruction) bool {
+	switch i := i.(type) {
+	case *ssa.BinOp:
+		switch i.Op {
+		case token.QUO, token.REM:
+			// An integer division by zero panics.
+			return isInteger(i.Type())
+		case token.SHL, token.SHR:
+			// A shift by a negative count panics.  The builder
+			// converts signed counts to unsigned ones.
+			y := i.Y
+			if c, ok := y.(*ssa.Convert); ok {
+				y = c.X
+			}
+			_, ok := y.(*ssa.Const)
+			return !ok && isSigned(y.Type())
+		case token.EQL, token.NEQ:
+			// A comparison of interfaces panics if their dynamic
+			// type is not comparable.
+			return hasInterface(i.X.Type())
+		}
+	case *ssa.MakeMap:
+		// A negative size panics.
+		_, ok := i.Reserve.(*ssa.Const)
+		return i.Reserve != nil && !ok
+	case *ssa.UnOp:
+		return i.Op == token.MUL
+	case *ssa.TypeAssert:
+		return !i.CommaOk
+	case *ssa.Lookup:
+		// An index of a string may be out of range; a map lookup
+		// does not panic.
+		_, ok := i.X.Type().Underlying().(*types.Map)
+		return !ok
+	case *ssa.Store:
+		_, ok := i.Addr.(*ssa.Alloc)
+		return !ok
+	case *ssa.Call:
+		// Of the builtins, only close panics, on a nil or closed
+		// channel, and the nil checks of the wrappers.
+		if b, ok := i.Call.Value.(*ssa.Builtin); ok {
+			return b.Name() == "close" || b.Name() == "ssa:wrapnilchk"
+		}
+		return true
+	case *ssa.Panic, *ssa.Go, *ssa.MapUpdate, *ssa.Send, *ssa.Select,
+		*ssa.IndexAddr, *ssa.Index, *ssa.FieldAddr, *ssa.Slice, *ssa.MakeSlice, *ssa.MakeChan:
+		return true
+	}
+	return false
+}
+
+// isSigned reports whether t is a signed integer type.
+func isSigned(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsInteger != 0 && b.Info()&types.IsUnsigned == 0
+}
+
+// hasInterface reports whether values of type t hold interfaces, which
+// are compared by their dynamic values.
+func hasInterface(t types.Type) bool {
+	switch t := t.Underlying().(type) {
+	case *types.Interface:
+		return true
+	case *types.Struct:
+		for k := 0; k < t.NumFields(); k++ {
+			if hasInterface(t.Field(k).Type()) {
+				return true
+			}
+		}
+	case *types.Array:
+		return hasInterface(t.Elem())
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeferInfo(t *testing.T) {
+	src := `package main
+
+func f(a bool, s []int) {
+	defer g()
+	if a {
+		panic("a")
+	}
+	for range s {
+		defer h()
+	}
+}
+
+func g() { recover() }
+
+func h() {}
+`
+	f := testFunc(t, src, "f")
+	d := deferInfo(f, newDefUse(f), findLoops(f))
+	site := func(s DeferSite) string {
+		return fmt.Sprintf("%s %s b%d loop=%v", s.ID, s.Call, s.Block, s.InLoop)
+	}
+	var got []string
+	for _, s := range d.Defers {
+		got = append(got, "defer "+site(s))
+	}
+	for _, p := range append(d.RunDefers, d.Panics...) {
+		var ds []string
+		for _, s := range p.Defers {
+			ds = append(ds, s.Call)
+		}
+		got = append(got, fmt.Sprintf("%s b%d: %s", p.ID, p.Block, strings.Join(ds, " ")))
+	}
+	want := []string{
+		"defer f-b0-0 g b0 loop=false",
+		"defer f-b5-0 h b5 loop=true",
+		"f-b6-0 b6: h g",
+		"f-b2-1 b2: g",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if d.Recover != f.Recover.Index {
+		t.Errorf("recover block %d, want %d", d.Recover, f.Recover.Index)
+	}
+}
+
+// TestDeferInfoPanics checks that the deferred calls registered before a
+// runtime panic or a panicking call run there too.
+func TestDeferInfoPanics(t *testing.T) {
+	src := `package main
+
+func k(p *int, m map[int]int) int {
+	n := *p
+	defer g()
+	m[n] = len(m)
+	defer h()
+	h()
+	return n / *p
+}
+
+func g() {}
+
+func h() {}
+`
+	f := testFunc(t, src, "k")
+	d := deferInfo(f, newDefUse(f), findLoops(f))
+	var got []string
+	for _, p := range d.Panics {
+		var ds []string
+		for _, s := range p.Defers {
+			ds = append(ds, s.Call)
+		}
+		got = append(got, fmt.Sprintf("%s: %s", p.ID, strings.Join(ds, " ")))
+	}
+	want := []string{"k-b0-3: g", "k-t2: h g"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
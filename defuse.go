@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Ref refers to an instruction or value by its ID in the rendered page.
+// For operands, Name is the register and ID the place of its definition.
+type Ref struct {
+	Name string
+	ID   string
+}
+
+// defUse assigns an ID to every value and instruction of a function so
+// that def-use chains can be followed in the UI and in the API.
+type defUse struct {
+	fn  string
+	ids map[interface{}]string
+}
+
+func newDefUse(f *ssa.Function) *defUse {
+	du := &defUse{funcID(f), make(map[interface{}]string)}
+	// Parameters are numbered, since several may be named _.
+	for k, p := range f.Params {
+		du.ids[p] = fmt.Sprintf("%s-param%d-%s", du.fn, k, p.Name())
+	}
+	for _, fv := range f.FreeVars {
+		du.ids[fv] = du.fn + "-freevar-" + fv.Name()
+	}
+	for _, b := range f.Blocks {
+		for k, i := range b.Instrs {
+			if v, ok := i.(ssa.Value); ok {
+				du.ids[i] = du.fn + "-" + v.Name()
+			} else {
+				du.ids[i] = fmt.Sprintf("%s-b%d-%d", du.fn, b.Index, k)
+			}
+		}
+	}
+	return du
+}
+
+// idChars drops or replaces the characters of function names which are not
+// allowed in the IDs of the page.
+var idChars = strings.NewReplacer("(", "", ")", "", "*", "", ".", "-", "$", "-")
+
+// funcID returns the name of f as used in IDs: qualified by the receiver
+// type for methods, so that (A).M and (*B).M give A-M and B-M, and with
+// anonymous functions numbered, as in f-1.
+func funcID(f *ssa.Function) string {
+	name := f.Name()
+	if f.Pkg != nil {
+		name = f.RelString(f.Pkg.Pkg)
+	}
+	return idChars.Replace(name)
+}
+
+// id returns the ID of an instruction, a parameter or a free variable.
+func (du *defUse) id(x interface{}) string {
+	return du.ids[x]
+}
+
+// operands returns the register operands of i and where they are defined.
+func (du *defUse) operands(i ssa.Instruction) []Ref {
+	var refs []Ref
+	for _, v := range operands(i) {
+		refs = append(refs, Ref{v.Name(), du.id(v)})
+	}
+	return refs
+}
+
+// referrers returns the instructions using v, in any block of the function.
+func (du *defUse) referrers(v ssa.Value) []Ref {
+	rs := v.Referrers()
+	if rs == nil {
+		return nil
+	}
+	var refs []Ref
+	seen := make(map[ssa.Instruction]bool)
+	for _, r := range *rs {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		refs = append(refs, Ref{r.String(), du.id(r)})
+	}
+	return refs
+}
+
+// link returns the disassembled form of i as HTML in which every register
+// operand is a clickable reference to its definition.  The operands are
+// cut out of the text by splitOperands, so that field names and the text
+// of constants are never taken for registers.
+func (du *defUse) link(i ssa.Instruction) template.HTML {
+	var ops []ssa.Value
+	var names []string
+	for _, op := range i.Operands(nil) {
+		if *op == nil {
+			continue
+		}
+		ops = append(ops, *op)
+		names = append(names, (*op).Name())
+	}
+	text, at := splitOperands(i.String(), names)
+	var out strings.Builder
+	if v, ok := i.(ssa.Value); ok {
+		fmt.Fprintf(&out, `<span class="reg" data-def="%s">%s</span> = `,
+			template.HTMLEscapeString(du.id(i)), template.HTMLEscapeString(v.Name()))
+	}
+	for k, t := range text {
+		out.WriteString(template.HTMLEscapeString(t))
+		if k >= len(at) {
+			continue
+		}
+		v := ops[at[k]]
+		if id := du.id(v); id != "" && isRegister(v) {
+			fmt.Fprintf(&out, `<span class="reg" data-def="%s">%s</span>`,
+				template.HTMLEscapeString(id), template.HTMLEscapeString(v.Name()))
+		} else {
+			out.WriteString(template.HTMLEscapeString(v.Name()))
+		}
+	}
+	return template.HTML(out.String())
+}
+
+// splitOperands cuts the operands out of the text of an instruction.
+// Operands are searched in order as whole words; those which are not
+// found stay part of the text.
+func splitOperands(s string, names []string) (text []string, at []int) {
+	isIdent := func(k int) bool {
+		if k < 0 || k >= len(s) {
+			return false
+		}
+		c := s[k]
+		return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+	}
+	pos := 0
+	for k, n := range names {
+		for from := pos; ; {
+			idx := strings.Index(s[from:], n)
+			if idx < 0 {
+				break
+			}
+			idx += from
+			if !isIdent(idx-1) && !isIdent(idx+len(n)) {
+				text = append(text, s[pos:idx])
+				at = append(at, k)
+				pos = idx + len(n)
+				break
+			}
+			from = idx + 1
+		}
+	}
+	return append(text, s[pos:]), at
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"go/types"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func TestDefUseParamIDs(t *testing.T) {
+	f := testFunc(t, "package main\n\nfunc f(a, _, _ int) int { return a }\n", "f")
+	du := newDefUse(f)
+	want := []string{"f-param0-a", "f-param1-_", "f-param2-_"}
+	for k, p := range f.Params {
+		if got := du.id(p); got != want[k] {
+			t.Errorf("param %d: got ID %q, want %q", k, got, want[k])
+		}
+	}
+}
+
+func TestDefUseMethodIDs(t *testing.T) {
+	const src = "package main\n\ntype A int\ntype B int\n\nfunc (a A) M() {}\n\nfunc (b *B) M() { func() {}() }\n"
+	pkg := testPackage(t, src)
+	method := func(recv types.Type) *ssa.Function {
+		sel := pkg.Prog.MethodSets.MethodSet(recv).Lookup(pkg.Pkg, "M")
+		return pkg.Prog.FuncValue(sel.Obj().(*types.Func))
+	}
+	a := method(pkg.Type("A").Type())
+	b := method(types.NewPointer(pkg.Type("B").Type()))
+	anon := b.AnonFuncs[0]
+	tests := []struct {
+		f    *ssa.Function
+		x    interface{}
+		want string
+	}{
+		{a, a.Params[0], "A-M-param0-a"},
+		{b, b.Params[0], "B-M-param0-b"},
+		{anon, anon.Blocks[0].Instrs[0], "B-M-1-b0-0"},
+	}
+	for _, tt := range tests {
+		if got := newDefUse(tt.f).id(tt.x); got != tt.want {
+			t.Errorf("%s: got ID %q, want %q", tt.f, got, tt.want)
+		}
+	}
+}
+
+// The field p and the text of the string constant are named like the
+// parameters.
+const linkSrc = `package main
+
+type T struct{ a, p int }
+
+func field(p *T) int { return p.p }
+
+func str(s string) { println("s", s) }
+`
+
+func TestDefUseLink(t *testing.T) {
+	pkg := testPackage(t, linkSrc)
+	tests := []struct {
+		fn    string
+		instr string
+		want  string
+	}{
+		{"field", "&p.p [#1]", `<span class="reg" data-def="field-t0">t0</span> = &amp;<span class="reg" data-def="field-param0-p">p</span>.p [#1]`},
+		{"str", `println("s":string, s)`, `<span class="reg" data-def="str-t0">t0</span> = println(&#34;s&#34;:string, <span class="reg" data-def="str-param0-s">s</span>)`},
+	}
+	for _, tt := range tests {
+		f := pkg.Func(tt.fn)
+		var found ssa.Instruction
+		for _, b := range f.Blocks {
+			for _, i := range b.Instrs {
+				if i.String() == tt.instr {
+					found = i
+				}
+			}
+		}
+		if found == nil {
+			var buf bytes.Buffer
+			ssa.WriteFunction(&buf, f)
+			t.Fatalf("no instruction %s in\n%s", tt.instr, &buf)
+		}
+		if got := string(newDefUse(f).link(found)); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.instr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskLRU keeps files named by a key and an extension in a directory, for
+// the snippets of diskStore and the results of resultCache.  The
+// modification time of a file is the last use of its key; beyond max
+// files the least recently used ones are removed.
+type diskLRU struct {
+	dir string
+	ext string // of the files, e.g. .json
+	max int
+
+	mu sync.Mutex
+	n  int // files in dir
+}
+
+// newDiskLRU returns the files in dir, removing the least recently used
+// ones beyond max.
+func newDiskLRU(dir, ext string, max int) (*diskLRU, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &diskLRU{dir: dir, ext: ext, max: max}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fs, err := l.files()
+	if err != nil {
+		return nil, err
+	}
+	l.n = len(fs)
+	return l, l.evict()
+}
+
+func (l *diskLRU) file(key string) string {
+	return filepath.Join(l.dir, key+l.ext)
+}
+
+// read returns the content of the file of key and marks it as used.
+func (l *diskLRU) read(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(l.file(key))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(l.file(key), now, now)
+	return b, nil
+}
+
+// write stores b as the file of key.  It is written under another name
+// and renamed, so that readers never see a partial file; the least
+// recently used files beyond the maximum are then removed.
+func (l *diskLRU) write(key string, b []byte) error {
+	tmp, err := ioutil.TempFile(l.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = os.Stat(l.file(key))
+	if err := os.Rename(tmp.Name(), l.file(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if os.IsNotExist(err) {
+		l.n++
+	}
+	return l.evict()
+}
+
+// files returns the files of the keys, least recently used first.
+func (l *diskLRU) files() ([]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var fs []os.FileInfo
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), l.ext) {
+			fs = append(fs, fi)
+		}
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].ModTime().Before(fs[j].ModTime()) })
+	return fs, nil
+}
+
+// evict removes the least recently used files beyond the maximum; l.mu
+// is held.
+func (l *diskLRU) evict() error {
+	if l.n <= l.max {
+		return nil
+	}
+	fs, err := l.files()
+	if err != nil {
+		return err
+	}
+	for len(fs) > l.max {
+		if err := os.Remove(filepath.Join(l.dir, fs[0].Name())); err != nil {
+			return err
+		}
+		fs = fs[1:]
+	}
+	l.n = len(fs)
+	return nil
+}
+
+// clear removes all files.
+func (l *diskLRU) clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fs, err := l.files()
+	if err != nil {
+		return err
+	}
+	for _, fi := range fs {
+		if err := os.Remove(filepath.Join(l.dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	l.n = 0
+	return nil
+}
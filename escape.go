@@ -0,0 +1,126 @@
+package main
+
+import (
+	"go/token"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"escape", "list the local variables allocated on the heap and why", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return escapeReport(f, in.defUse(f))
+	}})
+}
+
+// Escape describes a heap allocation: of a local variable, named by Var,
+// or of new(T), a composite literal or the backing array of a slice, whose
+// Var is the kind of the allocation, e.g. new or complit.
+type Escape struct {
+	Var    string
+	Value  string
+	ID     string
+	Pos    string
+	Causes []string
+}
+
+// EscapeReport lists the heap allocations of a function and counts its
+// heap and stack allocated local variables, those declared in the source.
+type EscapeReport struct {
+	Heap        []Escape
+	HeapLocals  int
+	StackLocals int
+}
+
+// escapeReport collects every Alloc of f marked Heap by the builder and
+// tries to explain why it escapes.
+func escapeReport(f *ssa.Function, du *defUse) EscapeReport {
+	var r EscapeReport
+	for _, b := range f.Blocks {
+		for _, i := range b.Instrs {
+			a, ok := i.(*ssa.Alloc)
+			if !ok {
+				continue
+			}
+			switch {
+			case !a.Heap:
+				if declared(a) {
+					r.StackLocals++
+				}
+				continue
+			case declared(a):
+				r.HeapLocals++
+			}
+			e := Escape{a.Comment, a.Name(), du.id(a), posString(f.Prog, a.Pos()), escapeCauses(a)}
+			r.Heap = append(r.Heap, e)
+		}
+	}
+	return r
+}
+
+// declared reports whether a allocates a variable declared in the source,
+// i.e. a is at the position of the declaration of the variable named by
+// its Comment.  The builder names other allocations by their kind.
+func declared(a *ssa.Alloc) bool {
+	pkg := a.Parent().Pkg
+	if pkg == nil || !a.Pos().IsValid() {
+		return false
+	}
+	s := pkg.Pkg.Scope().Innermost(a.Pos())
+	if s == nil {
+		return false
+	}
+	// Variables are only in scope after their declaration: look them
+	// up by name.
+	_, obj := s.LookupParent(a.Comment, token.NoPos)
+	return obj != nil && obj.Pos() == a.Pos()
+}
+
+// escapeCauses follows the address of a through the instructions which
+// derive new pointers from it and reports why it may outlive f.
+func escapeCauses(a *ssa.Alloc) []string {
+	var causes []string
+	add := func(c string) {
+		for _, d := range causes {
+			if d == c {
+				return
+			}
+		}
+		causes = append(causes, c)
+	}
+
+	seen := make(map[ssa.Value]bool)
+	work := []ssa.Value{a}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		for _, r := range *v.Referrers() {
+			switch r := r.(type) {
+			case *ssa.MakeClosure:
+				add("captured by closure " + r.Fn.Name())
+			case *ssa.Return:
+				add("returned")
+			case *ssa.Store:
+				if r.Val == v {
+					add("address taken: stored to memory")
+				}
+			case *ssa.Send:
+				add("address taken: sent on a channel")
+			case *ssa.MapUpdate:
+				add("address taken: stored in a map")
+			case ssa.CallInstruction:
+				add("address taken: passed to " + calleeName(r.Common(), a.Parent().Pkg.Pkg))
+			case *ssa.FieldAddr, *ssa.IndexAddr, *ssa.Slice, *ssa.ChangeType,
+				*ssa.Convert, *ssa.MakeInterface, *ssa.ChangeInterface, *ssa.Phi:
+				work = append(work, r.(ssa.Value))
+			}
+		}
+	}
+	if len(causes) == 0 {
+		add("unknown")
+	}
+	return causes
+}
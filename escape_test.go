@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const escapeSrc = `package main
+
+type T struct{ x int }
+
+func f(i int) (*int, func() int, int) {
+	a := 1
+	b := 2
+	c := T{}
+	var arr [3]int
+	arr[i] = 1
+	g := func() int { return b }
+	sink(&c.x)
+	sink(new(int))
+	sink(&(&T{}).x)
+	return &a, g, arr[0]
+}
+
+func sink(p *int) {}
+`
+
+func TestEscapeReport(t *testing.T) {
+	f := testFunc(t, escapeSrc, "f")
+	r := escapeReport(f, newDefUse(f))
+	if r.HeapLocals != 3 || r.StackLocals != 1 {
+		t.Errorf("got %d heap and %d stack locals, want 3 and 1", r.HeapLocals, r.StackLocals)
+	}
+	want := map[string][]string{
+		"a": {"returned"},
+		"b": {"captured by closure f$1"},
+		"c": {"address taken: passed to sink"},
+		// Allocations of no variable are reported, but not counted.
+		"new":     {"address taken: passed to sink"},
+		"complit": {"address taken: passed to sink"},
+	}
+	for _, e := range r.Heap {
+		if !reflect.DeepEqual(e.Causes, want[e.Var]) {
+			t.Errorf("%s: causes %q, want %q", e.Var, e.Causes, want[e.Var])
+		}
+		delete(want, e.Var)
+	}
+	for v := range want {
+		t.Errorf("%s is not reported", v)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Example is a program of the gallery showing how a Go construct is
+// lowered to SSA.
+type Example struct {
+	Name   string
+	Title  string
+	Doc    string
+	Source string
+}
+
+// examples is the gallery.  Every example is built in both build modes
+// by checkExamples when the server starts.
+var examples = []Example{
+	{"range", "Range over map, string and channel",
+		"Ranging over a map lowers to a range instruction creating an iterator and a next instruction returning (ok, key, value) tuples. " +
+			"Strings are iterated the same way, decoding a rune per step. " +
+			"Ranging over a channel is a loop around a receive with comma-ok whose ok ends the loop.",
+		`package main
+
+import "fmt"
+
+func sumMap(m map[string]int) int {
+	n := 0
+	for _, v := range m {
+		n += v
+	}
+	return n
+}
+
+func runes(s string) int {
+	n := 0
+	for i, r := range s {
+		n += i + int(r)
+	}
+	return n
+}
+
+func drain(ch chan int) int {
+	n := 0
+	for v := range ch {
+		n += v
+	}
+	return n
+}
+
+func main() {
+	ch := make(chan int, 2)
+	ch <- 1
+	ch <- 2
+	close(ch)
+	fmt.Println(sumMap(map[string]int{"a": 1}), runes("héllo"), drain(ch))
+}
+`},
+	{"closures", "Closures capturing loop variables",
+		"A variable captured by a closure escapes to the heap: it becomes an alloc marked heap and the closure a make closure instruction binding its address. " +
+			"The builder shipped with ssaview predates per-iteration loop variables, so all closures share one variable i allocated before the loop.",
+		`package main
+
+import "fmt"
+
+func main() {
+	var fs []func() int
+	for i := 0; i < 3; i++ {
+		fs = append(fs, func() int { return i * i })
+	}
+	for _, f := range fs {
+		fmt.Println(f())
+	}
+}
+`},
+	{"defer", "Defer, panic and recover",
+		"A defer statement becomes a defer instruction and every return is preceded by rundefers. " +
+			"A panic ends its block; when a deferred call recovers, control continues in the recover block, which returns the named results.",
+		`package main
+
+import "fmt"
+
+func safeDiv(a, b int) (q int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered: %v", r)
+		}
+	}()
+	if b == 0 {
+		panic("division by zero")
+	}
+	return a / b, nil
+}
+
+func main() {
+	fmt.Println(safeDiv(1, 0))
+}
+`},
+	{"select", "Select",
+		"A select statement becomes a select instruction yielding a tuple of the index of the chosen case, the ok flag of receives and the received values. " +
+			"The cases are then dispatched by comparing the index, like a switch.",
+		`package main
+
+import "fmt"
+
+func main() {
+	a := make(chan int, 1)
+	b := make(chan string, 1)
+	a <- 1
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-a:
+			fmt.Println("a", v)
+		case b <- "x":
+			fmt.Println("sent")
+		default:
+			fmt.Println("none")
+		}
+	}
+}
+`},
+	{"typeswitch", "Type switches",
+		"A type switch lowers to a chain of comma-ok type assertions, one per case, each followed by an if on the ok result. " +
+			"Cases listing several types keep the interface value.",
+		`package main
+
+import "fmt"
+
+func describe(x interface{}) string {
+	switch v := x.(type) {
+	case int:
+		return fmt.Sprint("int ", v+1)
+	case string:
+		return "string " + v
+	case error, fmt.Stringer:
+		return fmt.Sprint("error or stringer ", v)
+	}
+	return "unknown"
+}
+
+func main() {
+	fmt.Println(describe(1), describe("a"), describe(nil))
+}
+`},
+	{"methods", "Method values and method expressions",
+		"A method value c.Inc is a make closure of the synthetic bound function (*Counter).Inc$bound with the receiver as binding. " +
+			"A method expression (*Counter).Inc is the synthetic thunk function taking the receiver as first parameter.",
+		`package main
+
+import "fmt"
+
+type Counter struct{ n int }
+
+func (c *Counter) Inc() { c.n++ }
+
+func main() {
+	c := &Counter{}
+	inc := c.Inc
+	inc()
+	incr := (*Counter).Inc
+	incr(c)
+	fmt.Println(c.n)
+}
+`},
+	{"interfaces", "Interface conversion",
+		"Converting a concrete value to an interface is a make interface instruction; converting between interfaces is change interface, or a type assertion if the target has more methods. " +
+			"Calls of interface methods are invoke mode calls.",
+		`package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type upper struct{ r io.Reader }
+
+func (u upper) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	copy(p, strings.ToUpper(string(p[:n])))
+	return n, err
+}
+
+func main() {
+	var r io.Reader = upper{strings.NewReader("hello")}
+	if rc, ok := r.(io.ReadCloser); ok {
+		rc.Close()
+	}
+	var e interface{} = r
+	b := make([]byte, 5)
+	n, _ := e.(io.Reader).Read(b)
+	fmt.Println(string(b[:n]))
+}
+`},
+	{"results", "Multiple return values",
+		"A call of a function with several results yields a tuple, from which extract instructions take the single values. " +
+			"A return statement lists all results, and named results are locals loaded before the return.",
+		`package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func divmod(a, b int) (q, r int) {
+	q = a / b
+	r = a % b
+	return
+}
+
+func main() {
+	q, r := divmod(7, 2)
+	n, err := strconv.Atoi("42")
+	if err != nil {
+		return
+	}
+	fmt.Println(q, r, n)
+}
+`},
+	{"labels", "Labeled break and continue",
+		"Labeled break and continue statements become jumps to the done and post blocks of the labeled loop instead of the innermost one. " +
+			"The loop nesting tree shows the two loops.",
+		`package main
+
+import "fmt"
+
+func find(grid [][]int, x int) (int, int) {
+	row, col := -1, -1
+outer:
+	for i, line := range grid {
+		for j, v := range line {
+			if v < 0 {
+				continue outer
+			}
+			if v == x {
+				row, col = i, j
+				break outer
+			}
+		}
+	}
+	return row, col
+}
+
+func main() {
+	fmt.Println(find([][]int{{1, -1, 3}, {4, 5, 6}}, 5))
+}
+`},
+}
+
+// checkExamples builds every example with SanityCheckFunctions and in the
+// naive form and returns the first error.
+func checkExamples() (err error) {
+	defer func() {
+		// The analyses may panic on code they do not expect.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	for _, e := range examples {
+		for _, build := range []string{"true", "false"} {
+			o := newOptions()
+			o["ssabuild"] = build
+			if _, err := toSSA(context.Background(), o, strings.NewReader(e.Source), "main.go", "main"); err != nil {
+				return fmt.Errorf("example %s: %v", e.Name, err)
+			}
+		}
+	}
+	return nil
+}
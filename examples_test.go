@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExamples(t *testing.T) {
+	if err := checkExamples(); err != nil {
+		t.Fatal(err)
+	}
+	// The instructions each example is about.
+	want := map[string][]string{
+		"range":      {"*ssa.Range", "*ssa.Next", "*ssa.UnOp"},
+		"closures":   {"*ssa.MakeClosure"},
+		"defer":      {"*ssa.Defer", "*ssa.RunDefers", "*ssa.Panic"},
+		"select":     {"*ssa.Select"},
+		"typeswitch": {"*ssa.TypeAssert"},
+		"methods":    {"*ssa.MakeClosure"},
+		"interfaces": {"*ssa.MakeInterface", "*ssa.ChangeInterface"},
+		"results":    {"*ssa.Extract"},
+		"labels":     {"*ssa.Jump"},
+	}
+	names := make(map[string]bool)
+	for _, e := range examples {
+		if names[e.Name] {
+			t.Errorf("example %s listed twice", e.Name)
+		}
+		names[e.Name] = true
+		if e.Title == "" || e.Doc == "" {
+			t.Errorf("example %s has no title or doc", e.Name)
+		}
+		o := newOptions()
+		o["ssabuild"] = "true"
+		s, err := toSSA(context.Background(), o, strings.NewReader(e.Source), "main.go", "main")
+		if err != nil {
+			t.Fatalf("example %s: %v", e.Name, err)
+		}
+		types := make(map[string]bool)
+		for _, f := range s.Funcs {
+			for _, b := range f.Blocks {
+				for _, i := range b.Instrs {
+					types[i.Type] = true
+				}
+			}
+		}
+		for _, typ := range want[e.Name] {
+			if !types[typ] {
+				t.Errorf("example %s has no %s", e.Name, typ)
+			}
+		}
+	}
+	if len(names) != len(want) {
+		t.Errorf("examples %v, want %d", names, len(want))
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limits bound the resources of a build.  Zero values mean no limit; the
+// command line tools have none, the server reads them from the
+// environment, see loadLimits.
+var limits struct {
+	body     int64         // MAX_BODY_BYTES: size of a request body
+	packages int           // MAX_PACKAGES: packages loaded by a build
+	imports  []string      // IMPORT_ALLOW: patterns of allowed imports
+	timeout  time.Duration // BUILD_TIMEOUT: time of a build, waiting included
+}
+
+// builds is the worker pool of the server; nil runs builds unbounded.
+var builds *pool
+
+var (
+	errBusy     = errors.New("the server is busy: too many builds are waiting, try again later")
+	errDeadline = errors.New("the build was aborted: it did not finish in time")
+)
+
+// loadLimits reads the limits and the size of the worker pool from the
+// environment.  The defaults suit a small public server.
+func loadLimits() error {
+	var err error
+	num := func(name string, def int) int {
+		s := os.Getenv(name)
+		if s == "" || err != nil {
+			return def
+		}
+		n, e := strconv.Atoi(s)
+		if e != nil || n < 0 {
+			err = fmt.Errorf("bad %s %q, want a number", name, s)
+		}
+		return n
+	}
+	limits.body = int64(num("MAX_BODY_BYTES", 1<<20))
+	limits.packages = num("MAX_PACKAGES", 300)
+	limits.timeout = 30 * time.Second
+	if s := os.Getenv("BUILD_TIMEOUT"); s != "" {
+		d, e := time.ParseDuration(s)
+		if e != nil {
+			return fmt.Errorf("bad BUILD_TIMEOUT %q: %v", s, e)
+		}
+		limits.timeout = d
+	}
+	if s := os.Getenv("IMPORT_ALLOW"); s != "" {
+		limits.imports = strings.Split(s, ",")
+	}
+	deps.limit = int64(num("CACHE_BYTES", 256<<20))
+	deps.userLimit = int64(num("CACHE_USER_BYTES", 64<<20))
+	workers := num("MAX_BUILDS", runtime.NumCPU())
+	queue := num("MAX_QUEUE", 4*workers)
+	if err != nil {
+		return err
+	}
+	if workers > 0 {
+		builds = newPool(workers, queue)
+	}
+	return nil
+}
+
+// pool runs a bounded number of builds at a time.  Further builds wait in
+// a queue of bounded length.
+type pool struct {
+	workers chan struct{}
+	queue   chan struct{}
+}
+
+func newPool(workers, queue int) *pool {
+	return &pool{make(chan struct{}, workers), make(chan struct{}, workers+queue)}
+}
+
+// acquire waits for a free worker.  It fails at once with errBusy if the
+// queue is full and with errDeadline if ctx is done before a worker is.
+func (p *pool) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case p.queue <- struct{}{}:
+	default:
+		return errBusy
+	}
+	select {
+	case p.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		<-p.queue
+		return errDeadline
+	}
+}
+
+// release frees the worker of an acquired build.
+func (p *pool) release() {
+	if p == nil {
+		return
+	}
+	<-p.workers
+	<-p.queue
+}
+
+// importAllowed reports whether the import path matches one of the
+// allowed patterns: a path, a path followed by /... for it and the
+// packages below, or std for the standard library.
+func importAllowed(ctxt *build.Context, path string) bool {
+	if limits.imports == nil || path == "unsafe" {
+		return true
+	}
+	for _, p := range limits.imports {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == path:
+			return true
+		case strings.HasSuffix(p, "/..."):
+			if base := strings.TrimSuffix(p, "/..."); path == base || strings.HasPrefix(path, base+"/") {
+				return true
+			}
+		case p == "std":
+			if bp, err := ctxt.Import(path, "", build.FindOnly); err == nil && bp.Goroot {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkImports returns an error if the files import a package which is not
+// allowed.
+func checkImports(ctxt *build.Context, files []*ast.File) error {
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			if !importAllowed(ctxt, path) {
+				return fmt.Errorf("import of %s is not allowed on this server", path)
+			}
+		}
+	}
+	return nil
+}
+
+// loadGuard enforces the package limit and the deadline while packages
+// are loaded, recording the first limit hit as the error of the load.
+// The loader finds packages concurrently.
+type loadGuard struct {
+	ctx  context.Context
+	mu   sync.Mutex
+	seen map[string]bool
+	err  error
+}
+
+func newLoadGuard(ctx context.Context) *loadGuard {
+	return &loadGuard{ctx: ctx, seen: make(map[string]bool)}
+}
+
+// check counts the package with the import path and returns the error of
+// the load, if any.
+func (g *loadGuard) check(path string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil && g.ctx.Err() != nil {
+		g.err = errDeadline
+	}
+	g.seen[path] = true
+	if g.err == nil && limits.packages > 0 && len(g.seen) > limits.packages {
+		g.err = fmt.Errorf("the build imports more than %d packages, the limit of this server", limits.packages)
+	}
+	return g.err
+}
+
+func (g *loadGuard) find(ctxt *build.Context, path, fromDir string, mode build.ImportMode) (*build.Package, error) {
+	if err := g.check(path); err != nil {
+		return nil, err
+	}
+	return ctxt.Import(path, fromDir, mode)
+}
+
+// limitBody caps the size of the request bodies of h.
+func limitBody(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limits.body > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, limits.body)
+		}
+		h(w, r)
+	}
+}
+
+// errorStatus returns the HTTP status of an error of a request.
+func errorStatus(err error) int {
+	var mbe *http.MaxBytesError
+	switch {
+	case err == errBusy:
+		return http.StatusTooManyRequests
+	case err == errDeadline:
+		return http.StatusServiceUnavailable
+	case err == errForbidden:
+		return http.StatusForbidden
+	case errors.As(err, &mbe):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportAllowed(t *testing.T) {
+	defer func(imports []string) { limits.imports = imports }(limits.imports)
+	ctxt, _, err := Target{}.context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		allow []string
+		path  string
+		want  bool
+	}{
+		{nil, "anything/at/all", true},
+		{[]string{"fmt"}, "fmt", true},
+		{[]string{"fmt"}, "fmt/sub", false},
+		{[]string{"fmt"}, "unsafe", true},
+		{[]string{"github.com/a/..."}, "github.com/a", true},
+		{[]string{"github.com/a/..."}, "github.com/a/b/c", true},
+		{[]string{"github.com/a/..."}, "github.com/ab", false},
+		{[]string{"std"}, "net/http", true},
+		{[]string{"std"}, "github.com/a", false},
+		{[]string{" fmt ", "std"}, "fmt", true},
+	}
+	for _, tt := range tests {
+		limits.imports = tt.allow
+		if got := importAllowed(ctxt, tt.path); got != tt.want {
+			t.Errorf("%q %s: got %v, want %v", tt.allow, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := newPool(1, 1)
+	if err := p.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The second build waits in the queue until it times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.acquire(ctx); err != errDeadline {
+		t.Errorf("waiting build: error %v, want errDeadline", err)
+	}
+	// With the queue full, builds fail at once.
+	got := make(chan error)
+	go func() { got <- p.acquire(context.Background()) }()
+	for len(p.queue) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.acquire(context.Background()); err != errBusy {
+		t.Errorf("build beyond the queue: error %v, want errBusy", err)
+	}
+	p.release()
+	if err := <-got; err != nil {
+		t.Errorf("queued build: %v", err)
+	}
+	p.release()
+	if len(p.workers) != 0 || len(p.queue) != 0 {
+		t.Errorf("%d workers and %d queued after all releases", len(p.workers), len(p.queue))
+	}
+	// A nil pool does not bound builds.
+	var np *pool
+	if err := np.acquire(context.Background()); err != nil {
+		t.Error(err)
+	}
+	np.release()
+}
+
+func TestLoadGuard(t *testing.T) {
+	defer func(n int) { limits.packages = n }(limits.packages)
+	limits.packages = 2
+	g := newLoadGuard(context.Background())
+	for _, path := range []string{"a", "b", "a"} {
+		if err := g.check(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := g.check("c"); err == nil || !strings.Contains(err.Error(), "more than 2 packages") {
+		t.Errorf("c: error %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newLoadGuard(ctx).check("a"); err != errDeadline {
+		t.Errorf("canceled: error %v, want errDeadline", err)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errBusy, http.StatusTooManyRequests},
+		{errDeadline, http.StatusServiceUnavailable},
+		{fmt.Errorf("read: %w", &http.MaxBytesError{Limit: 1}), http.StatusRequestEntityTooLarge},
+		{errors.New("main.go:1:1: expected 'package'"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("%v: got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestDeadline checks that the steps of a build stop once the context is
+// done.
+func TestDeadline(t *testing.T) {
+	const src = "package main\n\nfunc main() { println(1) }\n"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := toSSA(ctx, newOptions(), strings.NewReader(src), "main.go", "main"); err != errDeadline {
+		t.Errorf("toSSA: error %v, want errDeadline", err)
+	}
+	pkg := testPackage(t, src)
+	if _, err := analyzePointers(ctx, pkg); err != errDeadline {
+		t.Errorf("analyzePointers: error %v, want errDeadline", err)
+	}
+	as, _ := selectAnalyses("")
+	in := &AnalysisInput{Pkg: pkg, Funcs: pkgFuncs(pkg), Options: newOptions(), Context: ctx}
+	if _, err := runAnalyses(as, in); err != errDeadline {
+		t.Errorf("runAnalyses: error %v, want errDeadline", err)
+	}
+}
+
+// TestRenderFormDeadline checks that an aborted render is answered at once
+// and that its build keeps its worker until it ends.
+func TestRenderFormDeadline(t *testing.T) {
+	defer func(p *pool, d time.Duration) { builds, limits.timeout = p, d }(builds, limits.timeout)
+	builds = newPool(1, 1)
+	limits.timeout = time.Nanosecond
+	r := httptest.NewRequest("POST", "/", strings.NewReader("source=package+main%0Afunc+main()+{}"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	if _, err := renderForm(r, newOptions(), ""); err != errDeadline {
+		t.Errorf("error %v, want errDeadline", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := builds.acquire(ctx); err != nil {
+		t.Fatalf("the worker was not released: %v", err)
+	}
+	builds.release()
+}
+
+func TestImportAllowHandlers(t *testing.T) {
+	defer testGOPATH(t, testLib)()
+	defer func(imports []string) { limits.imports = imports }(limits.imports)
+	limits.imports = []string{"example.com/..."}
+	get := func(h http.HandlerFunc, url string) (int, string) {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+	if code, body := get(filesHandler, "/api/files?pkg=example.com/lib"); code != http.StatusOK || !strings.Contains(body, "lib.go") {
+		t.Errorf("allowed package: %d %s", code, body)
+	}
+	if code, body := get(filesHandler, "/api/files?pkg=container/list&file=list.go"); code != http.StatusBadRequest || !strings.Contains(body, "not allowed") {
+		t.Errorf("other package: %d %s", code, body)
+	}
+	_, body := get(packagesHandler, "/api/packages")
+	var ps []string
+	if err := json.Unmarshal([]byte(body), &ps); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range ps {
+		if !strings.HasPrefix(p, "example.com") && p != "unsafe" {
+			t.Errorf("listed %s", p)
+		}
+	}
+	limits.imports = []string{"container/list"}
+	_, body = get(packagesHandler, "/api/packages")
+	ps = nil
+	json.Unmarshal([]byte(body), &ps)
+	if !reflect.DeepEqual(ps, []string{"container/list", "unsafe"}) {
+		t.Errorf("packages %v, want container/list and unsafe", ps)
+	}
+}
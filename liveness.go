@@ -0,0 +1,206 @@
+package main
+
+import (
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"liveness", "show the live values at the start and end of every block and the last uses of values", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return computeLiveness(f)
+	}})
+}
+
+// valueSet is a set of function-local SSA values.
+type valueSet map[ssa.Value]bool
+
+// liveness holds the live-in and live-out sets of every block of a
+// function and, for every instruction, the values whose last use it is.
+type liveness struct {
+	in      map[*ssa.BasicBlock]valueSet
+	out     map[*ssa.BasicBlock]valueSet
+	lastUse map[ssa.Instruction][]ssa.Value
+	order   map[ssa.Value]int
+}
+
+// isRegister reports whether v is a function-local value which has to be
+// kept alive between its definition and its uses.
+// Constants, globals, functions and builtins are not registers.
+func isRegister(v ssa.Value) bool {
+	switch v.(type) {
+	case *ssa.Parameter, *ssa.FreeVar:
+		return true
+	case ssa.Instruction:
+		return true
+	}
+	return false
+}
+
+// computeLiveness runs the classic backwards data-flow analysis over the
+// blocks of f:
+//
+//	out(b) = U in(s) for each successor s, plus the Phi operands of s
+//	         flowing along the edge b->s
+//	in(b)  = use(b) U (out(b) - def(b))
+//
+// Operands of Phi nodes are live at the end of the corresponding
+// predecessor and not at the start of the block holding the Phi.
+func computeLiveness(f *ssa.Function) *liveness {
+	lv := &liveness{
+		in:      make(map[*ssa.BasicBlock]valueSet),
+		out:     make(map[*ssa.BasicBlock]valueSet),
+		lastUse: make(map[ssa.Instruction][]ssa.Value),
+		order:   make(map[ssa.Value]int),
+	}
+	for _, p := range f.FreeVars {
+		lv.order[p] = len(lv.order)
+	}
+	for _, p := range f.Params {
+		lv.order[p] = len(lv.order)
+	}
+	use := make(map[*ssa.BasicBlock]valueSet)
+	def := make(map[*ssa.BasicBlock]valueSet)
+	for _, b := range f.Blocks {
+		use[b], def[b] = valueSet{}, valueSet{}
+		lv.in[b], lv.out[b] = valueSet{}, valueSet{}
+		for _, i := range b.Instrs {
+			if _, ok := i.(*ssa.Phi); !ok {
+				for _, v := range operands(i) {
+					if !def[b][v] {
+						use[b][v] = true
+					}
+				}
+			}
+			if v, ok := i.(ssa.Value); ok {
+				def[b][v] = true
+				lv.order[v] = len(lv.order)
+			}
+		}
+	}
+
+	// Iterate in reverse block order until a fixpoint is reached.
+	for changed := true; changed; {
+		changed = false
+		for k := len(f.Blocks) - 1; k >= 0; k-- {
+			b := f.Blocks[k]
+			out := valueSet{}
+			for _, s := range b.Succs {
+				for v := range lv.in[s] {
+					out[v] = true
+				}
+				for _, v := range phiOperands(s, b) {
+					out[v] = true
+				}
+			}
+			in := valueSet{}
+			for v := range use[b] {
+				in[v] = true
+			}
+			for v := range out {
+				if !def[b][v] {
+					in[v] = true
+				}
+			}
+			if len(in) != len(lv.in[b]) || len(out) != len(lv.out[b]) {
+				changed = true
+			}
+			lv.in[b], lv.out[b] = in, out
+		}
+	}
+
+	// Walk every block backwards starting with its live-out set; an
+	// operand that is not yet live is used here for the last time.
+	for _, b := range f.Blocks {
+		live := valueSet{}
+		for v := range lv.out[b] {
+			live[v] = true
+		}
+		for k := len(b.Instrs) - 1; k >= 0; k-- {
+			i := b.Instrs[k]
+			if v, ok := i.(ssa.Value); ok {
+				delete(live, v)
+			}
+			if phi, ok := i.(*ssa.Phi); ok {
+				// A Phi operand dies at the Phi unless it is
+				// still needed afterwards in this block.
+				for _, v := range operands(phi) {
+					if !lv.in[b][v] && !lv.contains(lv.lastUse[phi], v) {
+						lv.lastUse[phi] = append(lv.lastUse[phi], v)
+					}
+				}
+				continue
+			}
+			for _, v := range operands(i) {
+				if !live[v] {
+					live[v] = true
+					lv.lastUse[i] = append(lv.lastUse[i], v)
+				}
+			}
+		}
+	}
+	return lv
+}
+
+// operands returns the register operands of i without duplicates.
+func operands(i ssa.Instruction) []ssa.Value {
+	var vs []ssa.Value
+	seen := valueSet{}
+	for _, op := range i.Operands(nil) {
+		if *op == nil || !isRegister(*op) || seen[*op] {
+			continue
+		}
+		seen[*op] = true
+		vs = append(vs, *op)
+	}
+	return vs
+}
+
+// phiOperands returns the register operands of the Phi nodes of b that
+// flow in along the edge from pred.
+func phiOperands(b, pred *ssa.BasicBlock) []ssa.Value {
+	var vs []ssa.Value
+	for k, p := range b.Preds {
+		if p != pred {
+			continue
+		}
+		for _, i := range b.Instrs {
+			phi, ok := i.(*ssa.Phi)
+			if !ok {
+				break
+			}
+			if v := phi.Edges[k]; v != nil && isRegister(v) {
+				vs = append(vs, v)
+			}
+		}
+	}
+	return vs
+}
+
+func (lv *liveness) contains(vs []ssa.Value, v ssa.Value) bool {
+	for _, w := range vs {
+		if w == v {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the names of vs ordered by their definition.
+func (lv *liveness) names(vs []ssa.Value) []string {
+	sort.Slice(vs, func(i, j int) bool { return lv.order[vs[i]] < lv.order[vs[j]] })
+	var ns []string
+	for _, v := range vs {
+		ns = append(ns, v.Name())
+	}
+	return ns
+}
+
+// setNames is like names but for a valueSet.
+func (lv *liveness) setNames(s valueSet) []string {
+	var vs []ssa.Value
+	for v := range s {
+		vs = append(vs, v)
+	}
+	return lv.names(vs)
+}
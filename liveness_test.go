@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const loopSrc = `package main
+
+func f(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+`
+
+// f is
+//
+//	0: jump 3
+//	1: t0 = t2 + t3; t1 = t3 + 1:int; jump 3
+//	2: return t2
+//	3: t2 = phi [0: 0:int, 1: t0]; t3 = phi [0: 0:int, 1: t1]; t4 = t3 < n; if t4 goto 1 else 2
+func TestLiveness(t *testing.T) {
+	f := testFunc(t, loopSrc, "f")
+	lv := computeLiveness(f)
+	blocks := []struct {
+		in, out []string
+	}{
+		{[]string{"n"}, []string{"n"}},
+		{[]string{"n", "t2", "t3"}, []string{"n", "t0", "t1"}},
+		{[]string{"t2"}, nil},
+		{[]string{"n"}, []string{"n", "t2", "t3"}},
+	}
+	for k, want := range blocks {
+		b := f.Blocks[k]
+		if got := lv.setNames(lv.in[b]); !reflect.DeepEqual(got, want.in) {
+			t.Errorf("block %d: live in %v, want %v", k, got, want.in)
+		}
+		if got := lv.setNames(lv.out[b]); !reflect.DeepEqual(got, want.out) {
+			t.Errorf("block %d: live out %v, want %v", k, got, want.out)
+		}
+	}
+	lastUses := map[string][]string{
+		"t2 + t3":             {"t2"},
+		"t3 + 1:int":          {"t3"},
+		"return t2":           {"t2"},
+		"t3 < n":              nil,
+		"if t4 goto 1 else 2": {"t4"},
+	}
+	for _, b := range f.Blocks {
+		for _, i := range b.Instrs {
+			want, ok := lastUses[i.String()]
+			if !ok {
+				continue
+			}
+			if got := lv.names(lv.lastUse[i]); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: last use of %v, want %v", i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"loops", "show the loop nesting tree and the loop depth of every block", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return in.loopsOf(f)
+	}})
+}
+
+// Loop is a natural loop of a function.  Loops holds the loops nested
+// directly inside of it.
+type Loop struct {
+	Header int
+	Kind   string
+	Blocks []int
+	Exits  []int
+	Depth  int
+	Loops  []Loop
+}
+
+// loopInfo is the loop nesting forest of a function.
+type loopInfo struct {
+	loops []*loop
+	depth map[*ssa.BasicBlock]int
+}
+
+type loop struct {
+	header *ssa.BasicBlock
+	body   map[*ssa.BasicBlock]bool
+	parent *loop
+	depth  int
+}
+
+// findLoops finds the back edges of f, i.e. edges b->h where h dominates
+// b, and collects the natural loop of every header h: all blocks from
+// which a back edge to h can be reached without passing through h.
+func findLoops(f *ssa.Function) *loopInfo {
+	li := &loopInfo{depth: make(map[*ssa.BasicBlock]int)}
+	headers := make(map[*ssa.BasicBlock]*loop)
+	for _, b := range f.Blocks {
+		for _, h := range b.Succs {
+			if !h.Dominates(b) {
+				continue
+			}
+			l := headers[h]
+			if l == nil {
+				l = &loop{header: h, body: map[*ssa.BasicBlock]bool{h: true}}
+				headers[h] = l
+				li.loops = append(li.loops, l)
+			}
+			work := []*ssa.BasicBlock{b}
+			for len(work) > 0 {
+				n := work[len(work)-1]
+				work = work[:len(work)-1]
+				if l.body[n] {
+					continue
+				}
+				l.body[n] = true
+				work = append(work, n.Preds...)
+			}
+		}
+	}
+
+	// Natural loops with distinct headers are either disjoint or nested,
+	// so the parent of a loop is the smallest other loop containing its
+	// header.
+	sort.Slice(li.loops, func(i, j int) bool { return len(li.loops[i].body) > len(li.loops[j].body) })
+	for k, l := range li.loops {
+		for _, outer := range li.loops[:k] {
+			if outer.body[l.header] {
+				l.parent = outer
+			}
+		}
+		l.depth = 1
+		if l.parent != nil {
+			l.depth = l.parent.depth + 1
+		}
+		for b := range l.body {
+			if l.depth > li.depth[b] {
+				li.depth[b] = l.depth
+			}
+		}
+	}
+	return li
+}
+
+// tree returns the loop nesting forest, ordered by header.
+func (li *loopInfo) tree() []Loop {
+	return li.children(nil)
+}
+
+func (li *loopInfo) children(parent *loop) []Loop {
+	var ls []Loop
+	for _, l := range li.loops {
+		if l.parent != parent {
+			continue
+		}
+		var blocks, exits []int
+		exit := make(map[int]bool)
+		for b := range l.body {
+			blocks = append(blocks, b.Index)
+			for _, s := range b.Succs {
+				if !l.body[s] && !exit[s.Index] {
+					exit[s.Index] = true
+					exits = append(exits, s.Index)
+				}
+			}
+		}
+		sort.Ints(blocks)
+		sort.Ints(exits)
+		ls = append(ls, Loop{l.header.Index, loopKind(l.header), blocks, exits, l.depth, li.children(l)})
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Header < ls[j].Header })
+	return ls
+}
+
+// loopKind tells which statement the loop with the header h was built
+// from, using the comments the builder puts on the blocks and the
+// Next instruction of range loops over maps and strings.
+func loopKind(h *ssa.BasicBlock) string {
+	switch {
+	case strings.HasPrefix(h.Comment, "for."):
+		return "for"
+	case h.Comment == "rangeindex.loop":
+		return "range over array or slice"
+	case h.Comment == "rangechan.loop":
+		return "range over channel"
+	case h.Comment == "rangeiter.loop":
+		for _, i := range h.Instrs {
+			if n, ok := i.(*ssa.Next); ok && n.IsString {
+				return "range over string"
+			}
+		}
+		return "range over map"
+	}
+	return "loop"
+}
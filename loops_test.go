@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// loopString prints the loop forest ls as kind(blocks -> exits){children}.
+func loopString(ls []Loop) string {
+	var s []string
+	for _, l := range ls {
+		s = append(s, fmt.Sprintf("%s%v->%v{%s}", l.Kind, l.Blocks, l.Exits, loopString(l.Loops)))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestFindLoops(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"none", "println(s)", ""},
+		{"for", "for i := 0; i < 3; i++ {\n\t\tprintln(i)\n\t}", "for[1 3]->[2]{}"},
+		{"nested", "for _, x := range s {\n\t\tfor x > 0 {\n\t\t\tx--\n\t\t}\n\t}",
+			"range over array or slice[1 2 4 5]->[3]{for[4 5]->[1]{}}"},
+		{"string", "for _, r := range \"ab\" {\n\t\tprintln(r)\n\t}", "range over string[1 2]->[3]{}"},
+		{"map", "for k := range m {\n\t\tprintln(k)\n\t}", "range over map[1 2]->[3]{}"},
+		{"channel", "for x := range c {\n\t\tprintln(x)\n\t}", "range over channel[1 2]->[3]{}"},
+		{"goto", "l:\n\tif len(s) > 0 {\n\t\ts = s[1:]\n\t\tgoto l\n\t}", "loop[1 2]->[3]{}"},
+	}
+	for _, tt := range tests {
+		src := "package main\n\nfunc f(s []int, m map[int]int, c chan int) {\n\t" + tt.body + "\n}\n"
+		f := testFunc(t, src, "f")
+		if got := loopString(findLoops(f).tree()); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -6,14 +6,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/build"
 	"go/token"
+	"go/types"
+	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/ssa"
@@ -33,10 +45,25 @@ type Cb struct {
 }
 
 type SSA struct {
-	Funcs []Func
+	Funcs       []Func
+	Diagnostics []Diagnostic
+	Concurrency Concurrency
+	Query       []QueryResult
+	Views       map[string]bool // the views which were computed, see viewAnalysis
+}
+
+// Diagnostic is a finding of one of the analyses at an instruction.
+type Diagnostic struct {
+	Check   string
+	Func    string
+	ID      string
+	Pos     string
+	Message string
+	Related []Diagnostic
 }
 type Func struct {
 	Name     string
+	ID       string
 	Params   []Value
 	PString  string
 	FreeVars []Value
@@ -45,35 +72,80 @@ type Func struct {
 	LString  string
 	Blocks   []BB
 	BString  string
+	Escape   EscapeReport
+	Branches []Branch
+	Loops    []Loop
+	Metrics  Metrics
+	Defer    DeferInfo
+	Passes   []Pass
+	Notes    []string
 	//	AnonFuncs []Func
 }
 
 type Instr struct {
-	Name string
-	Type string
+	Name      string
+	Type      string
+	LastUse   []string
+	ID        string
+	Operands  []Ref
+	Referrers []Ref
+	Folded    string
+	Findings  []string
+	PointsTo  []string
+	Callees   []string
+	Notes     []string
+	HTML      template.HTML `json:"-"`
 }
 
 type Value struct {
-	Name string
-	Type string
+	Name      string
+	Type      string
+	ID        string
+	Referrers []Ref
+	PointsTo  []string
 }
 
 type BB struct {
-	Index  int
-	Instrs []Instr
-	Preds  []int
-	Succs  []int
+	Index   int
+	Instrs  []Instr
+	Preds   []int
+	Succs   []int
+	LiveIn  []string
+	LiveOut []string
+	Dead    bool
+	Depth   int
+	Ipdom   int
+	Ctrl    []CtrlDep
+	Notes   []string
 }
 
-var content = map[string]interface{}{
+// options are the data of the page: the texts, the render options of a
+// request and its results.  content holds the defaults; every request
+// works on its own copy, see newOptions.
+type options map[string]interface{}
+
+var content = options{
 	"Expl":          "Converts a valid go source file into the SSA represenation.",
-	"scPlaceHolder": "Enter a pure go program without errors.",
+	"scPlaceHolder": "Enter a pure go program without errors. Several files are separated by lines like -- util.go --.",
 	"sch3":          "Source Code",
 	"scRender":      "Render source code",
 	"sc":            "Source Code",
 	"ssah3":         "SSA representation",
 	//"ssa":           "Example SSA",
-	"pagename": "SSA view",
+	"pagename":  "SSA view",
+	"taint":     defaultTaintSpec,
+	"passes":    "",
+	"run":       "",
+	"query":     "",
+	"goos":      "",
+	"goarch":    "",
+	"tags":      "",
+	"cgo":       "",
+	"pkg":       "",
+	"file":      "",
+	"funcs":     "",
+	"optPasses": optPasses,
+	"examples":  examples,
 	"cbs": []Cb{
 		Cb{"Show call information", "functions", false},
 		//		Cb{"Show SSA type of each instruction", "ssaType", false},
@@ -82,9 +154,47 @@ var content = map[string]interface{}{
 	},
 }
 
+// newOptions returns a copy of content for a request.
+func newOptions() options {
+	o := make(options, len(content))
+	for k, v := range content {
+		o[k] = v
+	}
+	o["cbs"] = append([]Cb(nil), content["cbs"].([]Cb)...)
+	return o
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
-	http.HandleFunc("/", handler)
+	if err := checkExamples(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadLimits(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadResults(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	setAnalyses(content, "")
+	if err := loadSnippets(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	http.HandleFunc("/", limitBody(handler))
+	http.HandleFunc("/s/", limitBody(snippetHandler))
+	http.HandleFunc("/api", limitBody(apiHandler))
+	http.HandleFunc("/api/analyses", analysesHandler)
+	http.HandleFunc("/api/query", limitBody(queryHandler))
+	http.HandleFunc("/api/pointsto", limitBody(pointsToHandler))
+	http.HandleFunc("/api/packages", packagesHandler)
+	http.HandleFunc("/api/files", limitBody(filesHandler))
+	http.HandleFunc("/api/cache", cacheHandler)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = stdPort
@@ -174,69 +284,392 @@ func (m members) Less(i, j int) bool { return m[i].Pos() < m[j].Pos() }
 }
 */
 
-func toSSA(src io.Reader, file, pkg string) (SSA, error) {
-	var fs []Func
-	var conf loader.Config
+func toSSA(ctx context.Context, o options, src io.Reader, file, pkg string) (SSA, error) {
+	ctxt, sizes, err := o.target().context()
+	if err != nil {
+		return SSA{}, err
+	}
+	u := deps.universe(ctxt, sizes)
 
-	// Parse the file into a ssa file
-	f, _ := conf.ParseFile(file, src)
-	conf.CreateFromFiles("main.go", f)
-	p, _ := conf.Load()
-	buildsanity := content["ssabuild"] == true
-	var ssap *ssa.Program
-	if buildsanity {
-		ssap = ssautil.CreateProgram(p, ssa.SanityCheckFunctions)
-	} else {
-		ssap = ssautil.CreateProgram(p, ssa.NaiveForm)
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return SSA{}, err
+	}
+	// The source is an archive of files, see parseArchive.  Other files
+	// than Go files, e.g. go.mod, and files excluded by their build
+	// constraints are left out.
+	archive, err := parseArchive(b, file)
+	if err != nil {
+		return SSA{}, err
+	}
+	var files []*ast.File
+	for _, af := range archive {
+		if !strings.HasSuffix(af.Name, ".go") {
+			continue
+		}
+		if ok, err := matchFile(ctxt, filepath.Base(af.Name), af.Data); err != nil {
+			return SSA{}, err
+		} else if !ok {
+			continue
+		}
+		f, err := u.parseFile(af.Name, af.Data)
+		if err != nil {
+			return SSA{}, err
+		}
+		files = append(files, f)
 	}
+	if len(files) == 0 {
+		return SSA{}, fmt.Errorf("no Go files to build on %s/%s; all are excluded by their build constraints", ctxt.GOOS, ctxt.GOARCH)
+	}
+	if err := checkImports(ctxt, files); err != nil {
+		return SSA{}, err
+	}
+	mainpkg, done, err := u.create(newLoadGuard(ctx), o, "main.go", files)
+	if err != nil {
+		return SSA{}, err
+	}
+	s, err := buildSSA(ctx, o, mainpkg, sizes)
+	done(err)
+	return s, err
+}
 
-	// Build ssa prog to retrieve all information and the main pkg
-	ssap.Build()
+// importSSA converts the package with the import path to SSA.  If file
+// is given, src is used instead of the file of that name on disk.
+func importSSA(ctx context.Context, o options, path, file string, src []byte) (SSA, error) {
+	ctxt, sizes, err := o.target().context()
+	if err != nil {
+		return SSA{}, err
+	}
+	if !importAllowed(ctxt, path) {
+		return SSA{}, fmt.Errorf("import of %s is not allowed on this server", path)
+	}
+	// The dependencies never import the package, so they are found in
+	// the universe of the target, without the overlay.
+	u := deps.universe(ctxt, sizes)
+	if file != "" {
+		if ctxt, err = overlay(ctxt, path, file, src); err != nil {
+			return SSA{}, err
+		}
+	}
+	bp, err := ctxt.Import(path, "", 0)
+	if err != nil {
+		return SSA{}, err
+	}
+	if len(bp.CgoFiles) > 0 && ctxt.CgoEnabled {
+		// Only the loader runs cgo on the files of a package.
+		return loadSSA(ctx, o, ctxt, sizes, path)
+	}
+	files, size, err := u.parsePackage(ctxt, bp)
+	deps.addUser(u, size)
+	if err != nil {
+		return SSA{}, err
+	}
+	mainpkg, done, err := u.create(newLoadGuard(ctx), o, bp.ImportPath, files)
+	if err != nil {
+		return SSA{}, err
+	}
+	s, err := buildSSA(ctx, o, mainpkg, sizes)
+	done(err)
+	return s, err
+}
+
+// loadSSA loads the package with the import path and all its dependencies
+// with the loader, bypassing the cache of dependencies.
+func loadSSA(ctx context.Context, o options, ctxt *build.Context, sizes types.Sizes, path string) (SSA, error) {
+	conf := &loader.Config{Build: ctxt}
+	conf.TypeChecker.Sizes = sizes
+	conf.Import(path)
+	// Report the type errors, with their files and positions, instead of
+	// the summary of the loader, which checks packages concurrently.
+	var (
+		mu   sync.Mutex
+		errs []string
+	)
+	conf.TypeChecker.Error = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(errs) < 10 {
+			errs = append(errs, err.Error())
+		}
+	}
+	g := newLoadGuard(ctx)
+	conf.FindPackage = g.find
+	p, err := conf.Load()
+	if g.err != nil {
+		return SSA{}, g.err
+	}
+	if err != nil {
+		if len(errs) > 0 {
+			return SSA{}, errors.New(strings.Join(errs, "\n"))
+		}
+		return SSA{}, err
+	}
+	if ctx.Err() != nil {
+		return SSA{}, errDeadline
+	}
+	mode := ssa.NaiveForm
+	if o["ssabuild"] == "true" {
+		mode = ssa.SanityCheckFunctions
+	}
+	ssap := ssautil.CreateProgram(p, mode)
+	createUnsafe(ssap)
 	mainpkg := ssap.Package(p.InitialPackages()[0].Pkg)
+	for _, info := range p.AllPackages {
+		if ctx.Err() != nil {
+			return SSA{}, errDeadline
+		}
+		if info.Pkg != mainpkg.Pkg && buildable(&info.Info) {
+			buildDep(ssap.Package(info.Pkg))
+		}
+	}
+	return buildSSA(ctx, o, mainpkg, sizes)
+}
+
+// createUnsafe creates the package unsafe in prog.  The loader does not
+// list unsafe, but the builder calls the package initializer of every
+// import.  An empty file gives it one without members.
+func createUnsafe(prog *ssa.Program) {
+	if prog.Package(types.Unsafe) == nil {
+		prog.CreatePackage(types.Unsafe, []*ast.File{{Name: ast.NewIdent("unsafe")}}, new(types.Info), true)
+	}
+}
 
-	for _, m := range mainpkg.Members {
-		if m.Token() == token.FUNC {
-			f, ok := m.(*ssa.Function)
-			if ok {
-				var params []Value
-				for _, p := range f.Params {
-					v := Value{p.Name(), reflect.TypeOf(p).String()}
-					params = append(params, v)
+// buildSSA builds the SSA form of mainpkg and renders it.  It stops with
+// errDeadline once ctx is done, checked between the functions of the
+// analyses; only the builder itself runs to its end.
+func buildSSA(ctx context.Context, o options, mainpkg *ssa.Package, sizes types.Sizes) (SSA, error) {
+	var fs []Func
+	if ctx.Err() != nil {
+		return SSA{}, errDeadline
+	}
+	if err := buildPackage(mainpkg); err != nil {
+		return SSA{}, err
+	}
+	if ctx.Err() != nil {
+		return SSA{}, errDeadline
+	}
+
+	passes, err := parsePasses(o["passes"].(string))
+	if err != nil {
+		return SSA{}, err
+	}
+	as, err := selectAnalyses(o["run"].(string))
+	if err != nil {
+		return SSA{}, err
+	}
+	in := &AnalysisInput{Pkg: mainpkg, Funcs: pkgFuncs(mainpkg), Options: o, Sizes: sizes, Context: ctx}
+	an, err := runAnalyses(as, in)
+	if err != nil {
+		return SSA{}, err
+	}
+	pa, _ := an.pkgViews["pointsto"].(*pointer)
+
+	var show *regexp.Regexp
+	if g := o["funcs"].(string); g != "" {
+		show = compileGlob(g)
+	}
+	// Methods and anonymous functions are shown too, as the results of the
+	// analyses may refer to them.
+	for _, f := range in.Funcs {
+		if ctx.Err() != nil {
+			return SSA{}, errDeadline
+		}
+		if show != nil && !show.MatchString(f.Name()) {
+			continue
+		}
+		du := in.defUse(f)
+		id := funcID(f)
+		var params []Value
+		for _, p := range f.Params {
+			v := Value{p.Name(), reflect.TypeOf(p).String(), du.id(p), du.referrers(p), pa.pointsTo(p)}
+			params = append(params, v)
+		}
+		var freevars []Value
+		for _, fv := range f.FreeVars {
+			v := Value{fv.Name(), reflect.TypeOf(fv).String(), du.id(fv), du.referrers(fv), pa.pointsTo(fv)}
+			freevars = append(freevars, v)
+		}
+		var locals []Value
+		for _, l := range f.Locals {
+			v := Value{l.Name(), reflect.TypeOf(l).String(), du.id(l), du.referrers(l), pa.pointsTo(l)}
+			locals = append(locals, v)
+		}
+		// The views which were not run are left empty.
+		lv, ok := an.view("liveness", f).(*liveness)
+		if !ok {
+			lv = &liveness{}
+		}
+		cp, _ := an.view("constprop", f).(*sccp)
+		li, ok := an.view("loops", f).(*loopInfo)
+		if !ok {
+			li = &loopInfo{}
+		}
+		pd, _ := an.view("postdom", f).(*postDom)
+		var ctrl map[*ssa.BasicBlock][]CtrlDep
+		if pd != nil {
+			ctrl = pd.controlDeps(du)
+		}
+		var blocks []BB
+		for _, b := range f.Blocks {
+			var instrs []Instr
+			for _, i := range b.Instrs {
+				in := Instr{
+					Name:     i.String(),
+					Type:     reflect.TypeOf(i).String(),
+					LastUse:  lv.names(lv.lastUse[i]),
+					ID:       du.id(i),
+					Operands: du.operands(i),
+					Findings: an.findings[i],
+					Callees:  pa.calleesOf(i),
+					Notes:    an.instrs[i],
+					HTML:     du.link(i),
 				}
-				var freevars []Value
-				for _, fv := range f.FreeVars {
-					v := Value{fv.Name(), reflect.TypeOf(fv).String()}
-					freevars = append(freevars, v)
+				if cp != nil {
+					in.Folded = cp.folded(i)
 				}
-				var locals []Value
-				for _, l := range f.Locals {
-					v := Value{l.Name(), reflect.TypeOf(l).String()}
-					locals = append(locals, v)
+				if v, ok := i.(ssa.Value); ok {
+					in.Referrers = du.referrers(v)
+					in.PointsTo = pa.pointsTo(v)
 				}
-				var blocks []BB
-				for _, b := range f.Blocks {
-					var instrs []Instr
-					for _, i := range b.Instrs {
-						in := Instr{i.String(), reflect.TypeOf(i).String()}
-						instrs = append(instrs, in)
-					}
-					var preds []int
-					for _, p := range b.Preds {
-						preds = append(preds, p.Index)
-					}
-					var succs []int
-					for _, s := range b.Succs {
-						succs = append(succs, s.Index)
-					}
-					bb := BB{b.Index, instrs, preds, succs}
-					blocks = append(blocks, bb)
+				instrs = append(instrs, in)
+			}
+			var preds []int
+			for _, p := range b.Preds {
+				preds = append(preds, p.Index)
+			}
+			var succs []int
+			for _, s := range b.Succs {
+				succs = append(succs, s.Index)
+			}
+			bb := BB{
+				Index:   b.Index,
+				Instrs:  instrs,
+				Preds:   preds,
+				Succs:   succs,
+				LiveIn:  lv.setNames(lv.in[b]),
+				LiveOut: lv.setNames(lv.out[b]),
+				Dead:    cp != nil && !cp.exec[b],
+				Depth:   li.depth[b],
+				Ctrl:    ctrl[b],
+				Notes:   an.blocks[b],
+			}
+			if pd != nil {
+				bb.Ipdom = pd.idom(b)
+			}
+			blocks = append(blocks, bb)
+		}
+		fn := Func{
+			Name:     f.RelString(mainpkg.Pkg),
+			ID:       id,
+			Params:   params,
+			PString:  "par_" + id,
+			FreeVars: freevars,
+			FString:  "freevars_" + id,
+			Locals:   locals,
+			LString:  "locals_" + id,
+			Blocks:   blocks,
+			BString:  "blocks_" + id,
+			Loops:    li.tree(),
+			Defer:    DeferInfo{Recover: -1},
+			Passes:   optimize(f, passes, sizes),
+			Notes:    an.funcs[f],
+		}
+		if cp != nil {
+			fn.Branches = cp.branches(f, du)
+		}
+		if e, ok := an.view("escape", f).(EscapeReport); ok {
+			fn.Escape = e
+		}
+		if m, ok := an.view("metrics", f).(Metrics); ok {
+			fn.Metrics = m
+		}
+		if d, ok := an.view("defer", f).(DeferInfo); ok {
+			fn.Defer = d
+		}
+		fs = append(fs, fn)
+	}
+	var qs []QueryResult
+	if q := o["query"].(string); q != "" {
+		if qs, err = runQuery(mainpkg, q); err != nil {
+			return SSA{}, err
+		}
+	}
+	views := make(map[string]bool)
+	for name := range an.views {
+		views[name] = true
+	}
+	for name := range an.pkgViews {
+		views[name] = true
+	}
+	c, _ := an.pkgViews["concurrency"].(Concurrency)
+	return SSA{fs, an.diags, c, qs, views}, nil
+}
+
+// buildPackage builds the functions of pkg.  The builder panics on code
+// it does not support, e.g. generics and type aliases, which are common in
+// the standard library, and when a sanity check fails.
+func buildPackage(pkg *ssa.Package) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot build the SSA form of %s: %v; the builder does not support generics and type aliases", pkg.Pkg.Path(), r)
+		}
+	}()
+	pkg.Build()
+	return nil
+}
+
+// buildDep builds the functions of the dependency pkg, so that calls into
+// it can be followed, and reports whether it succeeded.  If the builder
+// fails on one of them, the functions of pkg keep no blocks, as if they
+// were external.
+func buildDep(pkg *ssa.Package) bool {
+	if buildPackage(pkg) != nil {
+		for _, f := range pkgFuncs(pkg) {
+			f.Blocks = nil
+		}
+		return false
+	}
+	return true
+}
+
+// posString returns the source position of pos in the form file:line:column
+// or the empty string if there is none.
+func posString(prog *ssa.Program, pos token.Pos) string {
+	if !pos.IsValid() {
+		return ""
+	}
+	return prog.Fset.Position(pos).String()
+}
+
+// pkgFuncs returns the source functions of pkg ordered by position:
+// package level functions, methods and, transitively, the anonymous
+// functions inside of them.
+func pkgFuncs(pkg *ssa.Package) []*ssa.Function {
+	var fs []*ssa.Function
+	var add func(f *ssa.Function)
+	add = func(f *ssa.Function) {
+		if f == nil {
+			return
+		}
+		fs = append(fs, f)
+		for _, a := range f.AnonFuncs {
+			add(a)
+		}
+	}
+	for _, m := range pkg.Members {
+		switch m := m.(type) {
+		case *ssa.Function:
+			add(m)
+		case *ssa.Type:
+			if named, ok := m.Type().(*types.Named); ok {
+				for k := 0; k < named.NumMethods(); k++ {
+					add(pkg.Prog.FuncValue(named.Method(k)))
 				}
-				fn := Func{f.Name(), params, "par_" + f.Name(), freevars, "freevars_" + f.Name(), locals, "locals_" + f.Name(), blocks, "blocks_" + f.Name()}
-				fs = append(fs, fn)
 			}
 		}
 	}
-	return SSA{fs}, nil
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Pos() < fs[j].Pos() })
+	return fs
 }
 
 // writeJSON attempts to serialize data and write it to w
@@ -244,7 +677,11 @@ func toSSA(src io.Reader, file, pkg string) (SSA, error) {
 func writeJSON(w http.ResponseWriter, data interface{}) error {
 	if err, ok := data.(error); ok {
 		data = struct{ Error string }{err.Error()}
-		w.WriteHeader(400)
+		status := errorStatus(err)
+		if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "10")
+		}
+		w.WriteHeader(status)
 	}
 	o, err := json.MarshalIndent(data, "", "   ")
 	if err != nil {
@@ -255,6 +692,37 @@ func writeJSON(w http.ResponseWriter, data interface{}) error {
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	show(w, r)
+}
+
+// snippetHandler renders the stored snippet /s/{id}.  Options in the URL
+// replace the stored ones.
+func snippetHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := snippets.Get(strings.TrimPrefix(r.URL.Path, "/s/"))
+	if err == errNoSnippet {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	if opts := r.URL.Query(); len(opts) > 0 {
+		s.Options = opts
+	}
+	r.PostForm = s.form()
+	show(w, r)
+}
+
+// show renders the page and, if a form was submitted, the SSA
+// representation of its source with a permalink to it.
+func show(w http.ResponseWriter, r *http.Request) {
 	tpl, err := ace.Load("base", "inner", nil)
 	if err != nil {
 		fmt.Printf("error %s", err.Error())
@@ -262,32 +730,326 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	handleError(err, w)
 
 	// Generate the SSA representation
-	if r.Method == "POST" {
-		err = r.ParseForm()
+	o := newOptions()
+	if r.PostForm != nil {
+		setOptions(o, r)
+
+		ssafs, err := renderForm(r, o, resultKey(r, o))
+		if err == errBusy || err == errDeadline {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
 		handleError(err, w)
+		o["sourceCode"] = r.PostFormValue("source")
+		o["ssa"] = ssafs
+		o["permalink"] = ""
+		if err == nil {
+			// Snippets too large to keep or which cannot be stored
+			// get no permalink.
+			s := newSnippet(r.PostForm)
+			id, err := snippets.Put(s)
+			switch {
+			case err == errSnippetTooLarge:
+			case err != nil:
+				handleError(err, w)
+			default:
+				o["permalink"] = "/s/" + id
+				if len(s.Options) > 0 {
+					o["permalink"] = "/s/" + id + "?" + s.Options.Encode()
+				}
+			}
+		}
+	}
 
-		// Iterate over the checkboxes
-		// content[cb.Name] is used in the toSSA algorithm
-		cbs := content["cbs"].([]Cb)
-		for i, cb := range cbs {
-			if r.PostFormValue(cb.Name) == "true" {
-				content[cb.Name] = "true"
-				cbs[i].Checked = true
-			} else {
-				content[cb.Name] = "false"
-				cbs[i].Checked = false
+	err = tpl.Execute(w, o)
+	handleError(err, w)
+}
+
+// renderForm converts the package of the posted import path, or else the
+// posted source, to SSA.  With a posted file name the source stands in for
+// that file of the package.  The build waits for a worker of the pool and
+// is aborted after the build timeout.  Results are cached by key, see
+// resultKey.
+//
+// The build runs in its own goroutine, so that the request is answered in
+// time even if the builder, which can not be interrupted, is still
+// running.  The build keeps its worker until it ends.
+func renderForm(r *http.Request, o options, key string) (SSA, error) {
+	if s, ok := results.get(key); ok {
+		return s, nil
+	}
+	ctx := r.Context()
+	if limits.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.timeout)
+		defer cancel()
+	}
+	if err := builds.acquire(ctx); err != nil {
+		return SSA{}, err
+	}
+	type result struct {
+		s   SSA
+		err error
+	}
+	done := make(chan result, 1)
+	// The handler goes on with o if the build is aborted.
+	bo := make(options, len(o))
+	for k, v := range o {
+		bo[k] = v
+	}
+	path, file, src := strings.TrimSpace(r.PostFormValue("pkg")), r.PostFormValue("file"), r.PostFormValue("source")
+	go func() {
+		var res result
+		defer func() {
+			// A panic would end the server outside of the handler.
+			if p := recover(); p != nil {
+				res.err = fmt.Errorf("the build failed: %v", p)
 			}
+			builds.release()
+			done <- res
+		}()
+		if path != "" {
+			res.s, res.err = importSSA(ctx, bo, path, file, []byte(src))
+		} else {
+			res.s, res.err = toSSA(ctx, bo, strings.NewReader(src), "main.go", "main")
+		}
+		if res.err == nil {
+			results.put(key, res.s)
 		}
+	}()
+	select {
+	case res := <-done:
+		return res.s, res.err
+	case <-ctx.Done():
+		return SSA{}, errDeadline
+	}
+}
 
-		ssaBytes := bytes.NewBufferString(r.PostFormValue("source"))
-		ssafs, err := toSSA(ssaBytes, "main.go", "main")
-		handleError(err, w)
-		content["sourceCode"] = r.PostFormValue("source")
-		content["ssa"] = ssafs
+// packagesHandler lists the import paths of the packages in GOROOT and
+// GOPATH which may be imported, see importAllowed.
+func packagesHandler(w http.ResponseWriter, r *http.Request) {
+	ps := []string{}
+	for _, p := range allPackages() {
+		if importAllowed(&build.Default, p) {
+			ps = append(ps, p)
+		}
 	}
+	writeJSON(w, ps)
+}
 
-	err = tpl.Execute(w, content)
-	handleError(err, w)
+// filesHandler lists the files of the package with the import path pkg
+// or, given a file, returns its source.
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, err)
+		return
+	}
+	ctxt, _, err := Target{r.FormValue("goos"), r.FormValue("goarch"), r.FormValue("tags"), r.FormValue("cgo")}.context()
+	if err != nil {
+		writeJSON(w, err)
+		return
+	}
+	if !importAllowed(ctxt, r.FormValue("pkg")) {
+		writeJSON(w, fmt.Errorf("import of %s is not allowed on this server", r.FormValue("pkg")))
+		return
+	}
+	dir, files, err := pkgFiles(ctxt, r.FormValue("pkg"))
+	if err != nil {
+		writeJSON(w, err)
+		return
+	}
+	file := r.FormValue("file")
+	if file == "" {
+		writeJSON(w, files)
+		return
+	}
+	for _, f := range files {
+		if f == file {
+			b, err := ioutil.ReadFile(filepath.Join(dir, f))
+			if err != nil {
+				writeJSON(w, err)
+				return
+			}
+			writeJSON(w, string(b))
+			return
+		}
+	}
+	writeJSON(w, fmt.Errorf("package %s has no file %s", r.FormValue("pkg"), file))
+}
+
+// apiHandler renders the posted source like handler does, but returns the
+// SSA representation as JSON.
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, fmt.Errorf("method %s not allowed, use POST", r.Method))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, err)
+		return
+	}
+	o := newOptions()
+	setOptions(o, r)
+	key := resultKey(r, o)
+	tag := etag(r, key)
+	if notModified(w, r, tag) {
+		return
+	}
+
+	ssafs, err := renderForm(r, o, key)
+	if err != nil {
+		writeJSON(w, err)
+		return
+	}
+	w.Header().Set("ETag", tag)
+	writeJSON(w, ssafs)
+}
+
+// pointsToHandler answers a points-to query for the value with the
+// posted ID, e.g. main-t3, main-param0-x or T-String-t0 for a method of T,
+// in the posted source.
+func pointsToHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, fmt.Errorf("method %s not allowed, use POST", r.Method))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, err)
+		return
+	}
+	o := newOptions()
+	setOptions(o, r)
+	// The answer only needs the points-to sets.
+	o["run"] = "pointsto"
+	key := resultKey(r, o)
+	tag := etag(r, key)
+	if notModified(w, r, tag) {
+		return
+	}
+
+	ssafs, err := renderForm(r, o, key)
+	if err != nil {
+		writeJSON(w, err)
+		return
+	}
+	w.Header().Set("ETag", tag)
+	id := r.PostFormValue("value")
+	for _, f := range ssafs.Funcs {
+		for _, vs := range [][]Value{f.Params, f.FreeVars} {
+			for _, v := range vs {
+				if v.ID == id {
+					writeJSON(w, struct {
+						Value    string
+						Type     string
+						PointsTo []string
+					}{v.Name, v.Type, v.PointsTo})
+					return
+				}
+			}
+		}
+		for _, b := range f.Blocks {
+			for _, i := range b.Instrs {
+				if i.ID == id {
+					writeJSON(w, struct {
+						Value    string
+						Type     string
+						PointsTo []string
+						Callees  []string
+					}{i.Name, i.Type, i.PointsTo, i.Callees})
+					return
+				}
+			}
+		}
+	}
+	writeJSON(w, fmt.Errorf("no value with ID %q", id))
+}
+
+// queryHandler returns the instructions of the posted source matched by
+// the posted query.
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, fmt.Errorf("method %s not allowed, use POST", r.Method))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, err)
+		return
+	}
+	o := newOptions()
+	setOptions(o, r)
+	if o["query"] == "" {
+		writeJSON(w, fmt.Errorf("missing query"))
+		return
+	}
+	key := resultKey(r, o)
+	tag := etag(r, key)
+	if notModified(w, r, tag) {
+		return
+	}
+
+	ssafs, err := renderForm(r, o, key)
+	if err != nil {
+		writeJSON(w, err)
+		return
+	}
+	w.Header().Set("ETag", tag)
+	writeJSON(w, ssafs.Query)
+}
+
+// analysesHandler lists the registered analyses.
+func analysesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, analysisList())
+}
+
+// setAnalyses stores the registered analyses as checkboxes for the UI,
+// checking those in the comma separated list of names, all of them for an
+// empty list and none for "none".
+func setAnalyses(o options, run string) {
+	names := make(map[string]bool)
+	for _, n := range strings.Split(run, ",") {
+		names[strings.TrimSpace(n)] = true
+	}
+	var cbs []Cb
+	for _, a := range analysisList() {
+		cbs = append(cbs, Cb{a.Doc, a.Name, strings.TrimSpace(run) == "" || names[a.Name]})
+	}
+	o["analyses"] = cbs
+}
+
+// setOptions iterates over the checkboxes and stores their values
+// together with the query, the analyses to run, the optimization passes,
+// the target and the taint spec in o.  The UI posts one analysis value per
+// checked analysis and the hidden analyses field, which tells unchecking
+// all of them apart from a form without checkboxes; the API posts a comma
+// separated run list.
+// o[cb.Name] is used in the toSSA algorithm
+func setOptions(o options, r *http.Request) {
+	o["query"] = r.PostFormValue("query")
+	o["run"] = r.PostFormValue("run")
+	if as := r.PostForm["analysis"]; len(as) > 0 {
+		o["run"] = strings.Join(as, ",")
+	} else if r.PostFormValue("analyses") != "" {
+		o["run"] = "none"
+	}
+	setAnalyses(o, o["run"].(string))
+	o["passes"] = r.PostFormValue("passes")
+	for _, k := range []string{"goos", "goarch", "tags", "cgo", "pkg", "file", "funcs"} {
+		o[k] = r.PostFormValue(k)
+	}
+	o["taint"] = defaultTaintSpec
+	if spec := r.PostFormValue("taint"); spec != "" {
+		o["taint"] = spec
+	}
+	cbs := o["cbs"].([]Cb)
+	for i, cb := range cbs {
+		if r.PostFormValue(cb.Name) == "true" {
+			o[cb.Name] = "true"
+			cbs[i].Checked = true
+		} else {
+			o[cb.Name] = "false"
+			cbs[i].Checked = false
+		}
+	}
 }
 
 func handleError(e error, w http.ResponseWriter) {
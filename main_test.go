@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// testPackage returns the built SSA form of src, a file of package main,
+// for the server platform.  The functions are lifted and sanity checked.
+func testPackage(t *testing.T, src string) *ssa.Package {
+	t.Helper()
+	o := newOptions()
+	o["ssabuild"] = "true"
+	return testPackageOptions(t, src, o)
+}
+
+// testPackageOptions returns the SSA form of src built with the options o.
+func testPackageOptions(t *testing.T, src string, o options) *ssa.Package {
+	t.Helper()
+	ctxt, sizes, err := o.target().context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := deps.universe(ctxt, sizes)
+	f, err := u.parseFile("main.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, done, err := u.create(newLoadGuard(context.Background()), o, "main.go", []*ast.File{f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = buildPackage(pkg)
+	t.Cleanup(func() { done(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+// testFunc returns the built function name of src.
+func testFunc(t *testing.T, src, name string) *ssa.Function {
+	t.Helper()
+	f := testPackage(t, src).Func(name)
+	if f == nil {
+		t.Fatalf("no function %s", name)
+	}
+	return f
+}
+
+// TestBuildSSAFuncs checks that methods and anonymous functions are shown,
+// in the order of the source, and restricted by the funcs glob.
+func TestBuildSSAFuncs(t *testing.T) {
+	const src = "package main\n\ntype T int\n\nfunc (t *T) M() { func() {}() }\n\nfunc main() {}\n"
+	tests := []struct {
+		funcs string
+		names []string
+		ids   []string
+	}{
+		{"", []string{"init", "(*T).M", "(*T).M$1", "main"}, []string{"init", "T-M", "T-M-1", "main"}},
+		{"M*", []string{"(*T).M", "(*T).M$1"}, []string{"T-M", "T-M-1"}},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		o["run"], o["funcs"] = "none", tt.funcs
+		s, err := toSSA(context.Background(), o, strings.NewReader(src), "main.go", "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names, ids []string
+		for _, f := range s.Funcs {
+			names, ids = append(names, f.Name), append(ids, f.ID)
+		}
+		if !reflect.DeepEqual(names, tt.names) || !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("funcs %q: got %v %v, want %v %v", tt.funcs, names, ids, tt.names, tt.ids)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"metrics", "show the complexity measures of every function", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return computeMetrics(f, in.loopsOf(f))
+	}})
+}
+
+// Metrics are complexity measures of a function.
+type Metrics struct {
+	Blocks       int
+	Edges        int
+	Cyclomatic   int
+	Instrs       int
+	InstrsByKind map[string]int
+	Phis         int
+	LoopDepth    int
+	Calls        int
+	HeapAllocs   int
+}
+
+// computeMetrics measures f.  The cyclomatic complexity is E - N + 2 of
+// the CFG.
+func computeMetrics(f *ssa.Function, li *loopInfo) Metrics {
+	m := Metrics{InstrsByKind: make(map[string]int)}
+	for _, b := range f.Blocks {
+		m.Blocks++
+		m.Edges += len(b.Succs)
+		if d := li.depth[b]; d > m.LoopDepth {
+			m.LoopDepth = d
+		}
+		for _, i := range b.Instrs {
+			m.Instrs++
+			m.InstrsByKind[strings.TrimPrefix(reflect.TypeOf(i).String(), "*ssa.")]++
+			switch i := i.(type) {
+			case *ssa.Phi:
+				m.Phis++
+			case ssa.CallInstruction:
+				m.Calls++
+			case *ssa.Alloc:
+				if i.Heap {
+					m.HeapAllocs++
+				}
+			}
+		}
+	}
+	if m.Blocks > 0 {
+		m.Cyclomatic = m.Edges - m.Blocks + 2
+	}
+	return m
+}
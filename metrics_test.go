@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	src := `package main
+
+func f(s []int) {
+	for _, x := range s {
+		for x > 0 {
+			x--
+		}
+	}
+}
+
+func g() *int {
+	p := new(int)
+	go println()
+	println(*p)
+	return p
+}
+`
+	tests := []struct {
+		name string
+		want Metrics
+	}{
+		// 0 -> 1 -> 2 | 3, 2 -> 5 -> 4 | 1, 4 -> 5
+		{"f", Metrics{Blocks: 6, Edges: 7, Cyclomatic: 3, Instrs: 15, Phis: 2, LoopDepth: 2, Calls: 1,
+			InstrsByKind: map[string]int{"BinOp": 4, "Call": 1, "If": 2, "IndexAddr": 1, "Jump": 3, "Phi": 2,
+				"Return": 1, "UnOp": 1}}},
+		{"g", Metrics{Blocks: 1, Edges: 0, Cyclomatic: 1, Instrs: 5, Calls: 2, HeapAllocs: 1,
+			InstrsByKind: map[string]int{"Alloc": 1, "Go": 1, "Call": 1, "UnOp": 1, "Return": 1}}},
+	}
+	pkg := testPackage(t, src)
+	for _, tt := range tests {
+		f := pkg.Func(tt.name)
+		if got := computeMetrics(f, findLoops(f)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(funcAnalysis{"nilness", "report dereferences of values which are nil or may be nil", checkNilness})
+}
+
+// Nilness of a value on a path through the CFG.
+const (
+	unknownNil = iota
+	isNil
+	nonNil
+)
+
+// checkNilness reports FieldAddr, IndexAddr, loads, map updates and
+// interface method calls whose operand is definitely nil or may be nil.
+//
+// Facts about values are learnt from If instructions comparing a value
+// against nil and carried down the dominator tree into the blocks which
+// can only be entered through the corresponding edge.  A value counts as
+// possibly nil if it is compared against nil anywhere in the function
+// but not known to be non-nil at the dereference.
+func checkNilness(f *ssa.Function) []Finding {
+	if len(f.Blocks) == 0 {
+		return nil
+	}
+	checked := make(map[ssa.Value]token.Pos)
+	for _, b := range f.Blocks {
+		for _, i := range b.Instrs {
+			if v, _, ok := nilCompare(i); ok {
+				if _, seen := checked[v]; !seen {
+					checked[v] = i.(ssa.Value).Pos()
+				}
+			}
+		}
+	}
+
+	var diags []Finding
+	var visit func(b *ssa.BasicBlock, facts map[ssa.Value]int)
+	visit = func(b *ssa.BasicBlock, facts map[ssa.Value]int) {
+		for _, i := range b.Instrs {
+			x, what := dereference(i)
+			if x == nil {
+				continue
+			}
+			switch nilnessOf(x, facts) {
+			case isNil:
+				diags = append(diags, Finding{Instr: i, Pos: instrPos(i), Message: "nil dereference in " + what})
+			case unknownNil:
+				if pos, ok := checked[x]; ok {
+					diags = append(diags, Finding{Instr: i, Pos: instrPos(i),
+						Message: "possible nil dereference in " + what + ": " + x.Name() + " is compared to nil at " + posString(f.Prog, pos)})
+				}
+			}
+			// Had x been nil, the program would have panicked.
+			facts = with(facts, x, nonNil)
+		}
+
+		for _, d := range b.Dominees() {
+			dfacts := facts
+			if len(d.Preds) == 1 && d.Preds[0] == b {
+				if v, n, ok := edgeFact(b, d); ok {
+					dfacts = with(facts, v, n)
+				}
+			}
+			visit(d, dfacts)
+		}
+	}
+	visit(f.Blocks[0], nil)
+	if f.Recover != nil {
+		visit(f.Recover, nil)
+	}
+	return diags
+}
+
+// with returns a copy of facts in which v has the nilness n.
+func with(facts map[ssa.Value]int, v ssa.Value, n int) map[ssa.Value]int {
+	if facts[v] == n {
+		return facts
+	}
+	m := make(map[ssa.Value]int, len(facts)+1)
+	for k, w := range facts {
+		m[k] = w
+	}
+	m[v] = n
+	return m
+}
+
+// edgeFact returns the nilness learnt for a value when the edge from
+// the If instruction ending b to its successor succ is taken.
+func edgeFact(b, succ *ssa.BasicBlock) (ssa.Value, int, bool) {
+	i, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If)
+	if !ok {
+		return nil, unknownNil, false
+	}
+	cmp, ok := i.Cond.(ssa.Instruction)
+	if !ok {
+		return nil, unknownNil, false
+	}
+	v, eq, ok := nilCompare(cmp)
+	if !ok {
+		return nil, unknownNil, false
+	}
+	// Succs[0] is the true branch.
+	if (succ == b.Succs[0]) == eq {
+		return v, isNil, true
+	}
+	return v, nonNil, true
+}
+
+// nilCompare reports whether i is a comparison v == nil or v != nil.
+func nilCompare(i ssa.Instruction) (v ssa.Value, eq bool, ok bool) {
+	op, ok := i.(*ssa.BinOp)
+	if !ok || (op.Op != token.EQL && op.Op != token.NEQ) {
+		return nil, false, false
+	}
+	switch {
+	case isNilConst(op.Y):
+		v = op.X
+	case isNilConst(op.X):
+		v = op.Y
+	default:
+		return nil, false, false
+	}
+	if isNilConst(v) {
+		return nil, false, false
+	}
+	return v, op.Op == token.EQL, true
+}
+
+func isNilConst(v ssa.Value) bool {
+	c, ok := v.(*ssa.Const)
+	return ok && c.IsNil()
+}
+
+// nilnessOf returns what is known about v being nil.
+func nilnessOf(v ssa.Value, facts map[ssa.Value]int) int {
+	switch v := v.(type) {
+	case *ssa.Const:
+		if v.IsNil() {
+			return isNil
+		}
+		return nonNil
+	case *ssa.Alloc, *ssa.MakeMap, *ssa.MakeSlice, *ssa.MakeChan, *ssa.MakeClosure,
+		*ssa.MakeInterface, *ssa.FieldAddr, *ssa.IndexAddr, *ssa.Function, *ssa.Global:
+		return nonNil
+	}
+	return facts[v]
+}
+
+// dereference returns the operand of i which must not be nil and a
+// description of the operation, or nil if i dereferences nothing.
+func dereference(i ssa.Instruction) (ssa.Value, string) {
+	switch i := i.(type) {
+	case *ssa.FieldAddr:
+		return i.X, "field selection"
+	case *ssa.IndexAddr:
+		// Indexing a nil slice is out of range, not a nil
+		// dereference.
+		if _, ok := i.X.Type().Underlying().(*types.Pointer); ok {
+			return i.X, "index of array pointer"
+		}
+	case *ssa.UnOp:
+		if i.Op == token.MUL {
+			return i.X, "load"
+		}
+	case *ssa.Store:
+		return i.Addr, "store"
+	case *ssa.MapUpdate:
+		return i.Map, "map update"
+	case ssa.CallInstruction:
+		if i.Common().IsInvoke() {
+			return i.Common().Value, "method call " + i.Common().Method.Name()
+		}
+	}
+	return nil, ""
+}
+
+// instrPos returns the position of i or, if it has none, the position of
+// the value it dereferences.
+func instrPos(i ssa.Instruction) token.Pos {
+	if pos := i.Pos(); pos.IsValid() {
+		return pos
+	}
+	if x, _ := dereference(i); x != nil {
+		return x.Pos()
+	}
+	return token.NoPos
+}
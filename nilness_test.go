@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// findings returns the findings of check for the function f of src as
+// line: message.  Findings without a position are at their instruction.
+func findings(t *testing.T, src string, check func(*ssa.Function) []Finding) []string {
+	t.Helper()
+	f := testFunc(t, src, "f")
+	var ms []string
+	for _, fd := range check(f) {
+		pos := fd.Pos
+		if !pos.IsValid() {
+			pos = fd.Instr.Pos()
+		}
+		ms = append(ms, fmt.Sprintf("%d: %s", f.Prog.Fset.Position(pos).Line, fd.Message))
+	}
+	return ms
+}
+
+func TestNilness(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []string
+	}{
+		{"nil branch", "if p == nil {\n\t\treturn p.x\n\t}\n\treturn 0",
+			[]string{"7: nil dereference in field selection"}},
+		{"non-nil branch", "if p != nil {\n\t\treturn p.x\n\t}\n\treturn 0", nil},
+		{"after check", "if p == nil {\n\t\tprintln()\n\t}\n\treturn p.x",
+			[]string{"9: possible nil dereference in field selection: p is compared to nil at main.go:6:7"}},
+		{"nil constant", "p = nil\n\treturn p.x", []string{"7: nil dereference in field selection"}},
+		{"early return", "if p == nil {\n\t\treturn 0\n\t}\n\treturn p.x", nil},
+		{"array pointer", "a = nil\n\treturn a[1]", []string{"7: nil dereference in index of array pointer"}},
+		{"nil slice", "if s == nil {\n\t\tprintln()\n\t}\n\tfor i := range s {\n\t\t_ = s[i]\n\t}\n\treturn 0", nil},
+	}
+	for _, tt := range tests {
+		src := "package main\n\ntype T struct{ x int }\n\nfunc f(p *T, s []int, a *[2]int) int {\n\t" + tt.body + "\n}\n"
+		if got := findings(t, src, checkNilness); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Pass is a function after an optimization pass together with the
+// difference to the function before the pass.  The first pass of a
+// function is the original function named "original" without a Diff.
+type Pass struct {
+	Name string
+	Text string
+	Diff []DiffLine
+}
+
+// DiffLine is a line of a Pass which was kept (" "), added ("+") or
+// removed ("-").
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// optPasses are the SSA-to-SSA transformations by name, in their default
+// order.
+var optPasses = []struct {
+	Name string
+	Doc  string
+	run  func(*optFunc) bool
+}{
+	{"constfold", "fold operations on constants and if instructions with a constant condition", (*optFunc).constFold},
+	{"copyprop", "replace phis with one edge and loads of a local right after a store to it by the copied value", (*optFunc).copyProp},
+	{"phielim", "replace phis whose edges are all the same value or the phi itself by that value", (*optFunc).phiElim},
+	{"dce", "remove instructions without side effects whose values are unused and locals which are only stored to", (*optFunc).dce},
+	{"blockopt", "delete unreachable blocks, thread jumps over empty blocks and fuse blocks with their single successor", (*optFunc).blockOpt},
+}
+
+// parsePasses parses a comma or space separated list of pass names.
+func parsePasses(s string) ([]string, error) {
+	names := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, n := range names {
+		if optPass(n) == nil {
+			var known []string
+			for _, p := range optPasses {
+				known = append(known, p.Name)
+			}
+			return nil, fmt.Errorf("unknown optimization pass %q, want one of %s", n, strings.Join(known, ", "))
+		}
+	}
+	return names, nil
+}
+
+func optPass(name string) func(*optFunc) bool {
+	for _, p := range optPasses {
+		if p.Name == name {
+			return p.run
+		}
+	}
+	return nil
+}
+
+// optimize runs the passes on a copy of f and returns every stage.
+func optimize(f *ssa.Function, passes []string, sizes types.Sizes) []Pass {
+	if len(passes) == 0 || len(f.Blocks) == 0 {
+		return nil
+	}
+	o := copyFunc(f)
+	o.sizes = sizes
+	ps := []Pass{{"original", o.String(), nil}}
+	for _, name := range passes {
+		// Passes are run until they change nothing more.
+		for optPass(name)(o) {
+		}
+		prev := ps[len(ps)-1].Text
+		text := o.String()
+		ps = append(ps, Pass{name, text, diff(prev, text)})
+	}
+	return ps
+}
+
+// optFunc is a mutable copy of the blocks and instructions of a function.
+// Instructions are kept as their disassembled text with the operands
+// cut out, so they can be printed after operands were replaced.
+type optFunc struct {
+	blocks  []*optBlock
+	recover *optBlock
+	sizes   types.Sizes
+}
+
+type optBlock struct {
+	index   int
+	comment string
+	instrs  []*optInstr
+	preds   []*optBlock
+	succs   []*optBlock
+	removed bool
+}
+
+// optInstr is an instruction whose text is text[0], args[at[0]],
+// text[1], ... .  Phi, If and Jump instructions are printed from their
+// block instead since the block numbers change.
+type optInstr struct {
+	orig  ssa.Instruction
+	kind  string // "phi", "if", "jump" or "" for other instructions
+	value *optVal
+	args  []*optVal
+	ops   []ssa.Value // the original operands of args
+	text  []string
+	at    []int
+	block *optBlock
+}
+
+// optVal is the value of an instruction or a constant, parameter, free
+// variable, global or function.
+type optVal struct {
+	def   *optInstr
+	name  string
+	konst constant.Value
+}
+
+func copyFunc(f *ssa.Function) *optFunc {
+	o := &optFunc{}
+	blocks := make(map[*ssa.BasicBlock]*optBlock)
+	for _, b := range f.Blocks {
+		ob := &optBlock{index: b.Index, comment: b.Comment}
+		blocks[b] = ob
+		o.blocks = append(o.blocks, ob)
+	}
+	o.recover = blocks[f.Recover]
+	vals := make(map[ssa.Value]*optVal)
+	val := func(v ssa.Value) *optVal {
+		ov := vals[v]
+		if ov == nil {
+			ov = &optVal{name: v.Name()}
+			if c, ok := v.(*ssa.Const); ok {
+				ov.konst = c.Value
+			}
+			vals[v] = ov
+		}
+		return ov
+	}
+	for _, b := range f.Blocks {
+		ob := blocks[b]
+		for _, p := range b.Preds {
+			ob.preds = append(ob.preds, blocks[p])
+		}
+		for _, s := range b.Succs {
+			ob.succs = append(ob.succs, blocks[s])
+		}
+		for _, i := range b.Instrs {
+			oi := &optInstr{orig: i, block: ob}
+			switch i.(type) {
+			case *ssa.Phi:
+				oi.kind = "phi"
+			case *ssa.If:
+				oi.kind = "if"
+			case *ssa.Jump:
+				oi.kind = "jump"
+			}
+			if v, ok := i.(ssa.Value); ok {
+				oi.value = val(v)
+				oi.value.def = oi
+			}
+			var names []string
+			for _, op := range i.Operands(nil) {
+				if *op == nil {
+					continue
+				}
+				oi.ops = append(oi.ops, *op)
+				oi.args = append(oi.args, val(*op))
+				names = append(names, (*op).Name())
+			}
+			oi.text, oi.at = splitOperands(i.String(), names)
+			ob.instrs = append(ob.instrs, oi)
+		}
+	}
+	return o
+}
+
+func (i *optInstr) String() string {
+	var buf bytes.Buffer
+	if i.value != nil {
+		fmt.Fprintf(&buf, "%s = ", i.value.name)
+	}
+	switch i.kind {
+	case "phi":
+		buf.WriteString("phi [")
+		for k, a := range i.args {
+			if k > 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(&buf, "%d: %s", i.block.preds[k].index, a.name)
+		}
+		buf.WriteString("]")
+		if c := i.orig.(*ssa.Phi).Comment; c != "" {
+			buf.WriteString(" #" + c)
+		}
+	case "if":
+		fmt.Fprintf(&buf, "if %s goto %d else %d", i.args[0].name, i.block.succs[0].index, i.block.succs[1].index)
+	case "jump":
+		fmt.Fprintf(&buf, "jump %d", i.block.succs[0].index)
+	default:
+		for k, t := range i.text {
+			buf.WriteString(t)
+			if k < len(i.at) {
+				buf.WriteString(i.args[i.at[k]].name)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// String prints the function like ssa.WriteFunction prints its blocks.
+func (o *optFunc) String() string {
+	var buf bytes.Buffer
+	for _, b := range o.blocks {
+		var preds, succs []int
+		for _, p := range b.preds {
+			preds = append(preds, p.index)
+		}
+		for _, s := range b.succs {
+			succs = append(succs, s.index)
+		}
+		fmt.Fprintf(&buf, "%d: %s P:%v S:%v\n", b.index, b.comment, preds, succs)
+		for _, i := range b.instrs {
+			fmt.Fprintf(&buf, "\t%s\n", i)
+		}
+	}
+	return buf.String()
+}
+
+// replace replaces all uses of old by v.
+func (o *optFunc) replace(old, v *optVal) {
+	for _, b := range o.blocks {
+		for _, i := range b.instrs {
+			for k, a := range i.args {
+				if a == old {
+					i.args[k] = v
+				}
+			}
+		}
+	}
+}
+
+// uses returns the instructions using v.
+func (o *optFunc) uses(v *optVal) []*optInstr {
+	var is []*optInstr
+	for _, b := range o.blocks {
+		for _, i := range b.instrs {
+			for _, a := range i.args {
+				if a == v {
+					is = append(is, i)
+					break
+				}
+			}
+		}
+	}
+	return is
+}
+
+// remove deletes the instruction i from its block.
+func (i *optInstr) remove() {
+	b := i.block
+	for k, j := range b.instrs {
+		if j == i {
+			b.instrs = append(b.instrs[:k], b.instrs[k+1:]...)
+			return
+		}
+	}
+}
+
+// removePred removes the edge from p to b together with the edges of the
+// phis of b.
+func (b *optBlock) removePred(p *optBlock) {
+	for k, q := range b.preds {
+		if q != p {
+			continue
+		}
+		b.preds = append(b.preds[:k], b.preds[k+1:]...)
+		for _, i := range b.instrs {
+			if i.kind == "phi" {
+				i.args = append(i.args[:k], i.args[k+1:]...)
+				i.ops = append(i.ops[:k], i.ops[k+1:]...)
+			}
+		}
+		return
+	}
+}
+
+// constFold replaces BinOp, UnOp and Convert instructions on constants by
+// their value, evaluated like the constant propagation does, and turns
+// if instructions with a constant condition into jumps.
+func (o *optFunc) constFold() bool {
+	changed := false
+	for _, b := range o.blocks {
+		for _, i := range append([]*optInstr(nil), b.instrs...) {
+			switch orig := i.orig.(type) {
+			case *ssa.BinOp, *ssa.UnOp, *ssa.Convert:
+				if i.kind != "" {
+					continue
+				}
+				s := &sccp{values: make(map[ssa.Value]lattice), sizes: o.sizes}
+				for k, a := range i.args {
+					if a.konst != nil {
+						s.values[i.ops[k]] = lattice{constVal, a.konst}
+					}
+				}
+				l := s.eval(orig.(ssa.Value), nil)
+				if l.state != constVal {
+					continue
+				}
+				v := orig.(ssa.Value)
+				c := ssa.NewConst(l.val, v.Type())
+				o.replace(i.value, &optVal{name: c.Name(), konst: c.Value})
+				i.remove()
+				changed = true
+			case *ssa.If:
+				if i.kind != "if" || i.args[0].konst == nil {
+					continue
+				}
+				keep, drop := b.succs[0], b.succs[1]
+				if !constant.BoolVal(i.args[0].konst) {
+					keep, drop = drop, keep
+				}
+				drop.removePred(b)
+				b.succs = []*optBlock{keep}
+				i.kind, i.args, i.ops = "jump", nil, nil
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// copyProp replaces copies of values by the values themselves.
+func (o *optFunc) copyProp() bool {
+	changed := false
+	for _, b := range o.blocks {
+		for _, i := range append([]*optInstr(nil), b.instrs...) {
+			if i.kind == "phi" && len(i.args) == 1 && i.args[0] != i.value {
+				o.replace(i.value, i.args[0])
+				i.remove()
+				changed = true
+			}
+		}
+	}
+
+	// Loads of locals which are only stored to and loaded from.
+	for _, b := range o.blocks {
+		for _, a := range b.instrs {
+			if _, ok := a.orig.(*ssa.Alloc); !ok || !o.scalarLocal(a.value) {
+				continue
+			}
+			for _, c := range o.blocks {
+				var cur *optVal
+				for _, i := range append([]*optInstr(nil), c.instrs...) {
+					if len(i.args) == 0 || i.args[0] != a.value {
+						continue
+					}
+					switch i.orig.(type) {
+					case *ssa.Store:
+						cur = i.args[1]
+					case *ssa.UnOp:
+						if cur != nil {
+							o.replace(i.value, cur)
+							i.remove()
+							changed = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// scalarLocal reports whether the local v is only used as the address of
+// stores and loads.
+func (o *optFunc) scalarLocal(v *optVal) bool {
+	for _, u := range o.uses(v) {
+		switch orig := u.orig.(type) {
+		case *ssa.Store:
+			if u.args[1] == v {
+				return false
+			}
+		case *ssa.UnOp:
+			if orig.Op != token.MUL {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// phiElim removes phis which merge a single value.
+func (o *optFunc) phiElim() bool {
+	changed := false
+	for _, b := range o.blocks {
+		for _, i := range append([]*optInstr(nil), b.instrs...) {
+			if i.kind != "phi" {
+				continue
+			}
+			var v *optVal
+			trivial := true
+			for _, a := range i.args {
+				if a == i.value || a == v {
+					continue
+				}
+				if v != nil {
+					trivial = false
+					break
+				}
+				v = a
+			}
+			if trivial && v != nil {
+				o.replace(i.value, v)
+				i.remove()
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// dce removes unused instructions which have no side effects and can not
+// panic.
+func (o *optFunc) dce() bool {
+	changed := false
+	for _, b := range o.blocks {
+		for _, i := range append([]*optInstr(nil), b.instrs...) {
+			if i.value == nil || !pure(i) {
+				continue
+			}
+			uses := o.uses(i.value)
+			if _, ok := i.orig.(*ssa.Alloc); ok && o.scalarLocal(i.value) {
+				// A local which is never loaded.
+				loaded := false
+				for _, u := range uses {
+					if _, ok := u.orig.(*ssa.UnOp); ok {
+						loaded = true
+					}
+				}
+				if loaded {
+					continue
+				}
+				for _, u := range uses {
+					u.remove()
+				}
+				uses = nil
+			}
+			if len(uses) == 0 {
+				i.remove()
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// pure reports whether i can be removed if its value is unused: it has no
+// effects and cannot panic.
+func pure(i *optInstr) bool {
+	switch orig := i.orig.(type) {
+	case *ssa.UnOp:
+		return orig.Op != token.ARROW && !mayPanic(orig)
+	case *ssa.BinOp, *ssa.MakeMap, *ssa.TypeAssert, *ssa.Phi, *ssa.Convert, *ssa.ChangeType, *ssa.ChangeInterface,
+		*ssa.MakeInterface, *ssa.MakeClosure, *ssa.Field, *ssa.Extract, *ssa.Alloc:
+		return !mayPanic(orig)
+	}
+	return false
+}
+
+// blockOpt simplifies the CFG like the optimizations of the SSA builder
+// in blockopt.go and numbers the blocks again.
+func (o *optFunc) blockOpt() bool {
+	changed := o.deleteUnreachable()
+	for _, b := range append([]*optBlock(nil), o.blocks...) {
+		if !b.removed && (o.jumpThreading(b) || o.fuse(b)) {
+			changed = true
+		}
+	}
+	for k, b := range o.blocks {
+		b.index = k
+	}
+	return changed
+}
+
+func (o *optFunc) deleteUnreachable() bool {
+	reached := make(map[*optBlock]bool)
+	var mark func(b *optBlock)
+	mark = func(b *optBlock) {
+		if b == nil || reached[b] {
+			return
+		}
+		reached[b] = true
+		for _, s := range b.succs {
+			mark(s)
+		}
+	}
+	mark(o.blocks[0])
+	mark(o.recover)
+	var blocks []*optBlock
+	for _, b := range o.blocks {
+		if reached[b] {
+			blocks = append(blocks, b)
+			continue
+		}
+		b.removed = true
+		for _, s := range b.succs {
+			if reached[s] {
+				s.removePred(b)
+			}
+		}
+	}
+	changed := len(blocks) != len(o.blocks)
+	o.blocks = blocks
+	return changed
+}
+
+// jumpThreading turns a->b->c into a->c if b is just a jump and c has no
+// phis.  An if of a with both edges going to c then is replaced by a jump.
+func (o *optFunc) jumpThreading(b *optBlock) bool {
+	if b == o.blocks[0] || b == o.recover || len(b.instrs) != 1 || b.instrs[0].kind != "jump" {
+		return false
+	}
+	c := b.succs[0]
+	if c == b {
+		return false
+	}
+	for _, i := range c.instrs {
+		if i.kind == "phi" {
+			return false
+		}
+	}
+	for _, a := range b.preds {
+		for k, s := range a.succs {
+			if s == b {
+				a.succs[k] = c
+			}
+		}
+	}
+	var preds []*optBlock
+	for _, p := range c.preds {
+		if p == b {
+			preds = append(preds, b.preds...)
+		} else {
+			preds = append(preds, p)
+		}
+	}
+	c.preds = preds
+	for _, a := range b.preds {
+		if len(a.succs) == 2 && a.succs[0] == c && a.succs[1] == c {
+			i := a.instrs[len(a.instrs)-1]
+			i.kind, i.args, i.ops = "jump", nil, nil
+			a.succs = a.succs[:1]
+			// c has no phis, so either edge of a can be removed.
+			for k := len(c.preds) - 1; k >= 0; k-- {
+				if c.preds[k] == a {
+					c.preds = append(c.preds[:k], c.preds[k+1:]...)
+					break
+				}
+			}
+		}
+	}
+	o.removeBlock(b)
+	return true
+}
+
+// fuse merges a with its successor if a ends with a jump and is the only
+// predecessor of it.
+func (o *optFunc) fuse(a *optBlock) bool {
+	if len(a.succs) != 1 || len(a.instrs) == 0 || a.instrs[len(a.instrs)-1].kind != "jump" {
+		return false
+	}
+	c := a.succs[0]
+	if c == a || c == o.blocks[0] || c == o.recover || len(c.preds) != 1 {
+		return false
+	}
+	a.instrs = a.instrs[:len(a.instrs)-1]
+	for _, i := range c.instrs {
+		if i.kind == "phi" {
+			o.replace(i.value, i.args[0])
+			continue
+		}
+		i.block = a
+		a.instrs = append(a.instrs, i)
+	}
+	a.succs = c.succs
+	for _, s := range c.succs {
+		for k, p := range s.preds {
+			if p == c {
+				s.preds[k] = a
+			}
+		}
+	}
+	o.removeBlock(c)
+	return true
+}
+
+func (o *optFunc) removeBlock(b *optBlock) {
+	for k, c := range o.blocks {
+		if c == b {
+			b.removed = true
+			o.blocks = append(o.blocks[:k], o.blocks[k+1:]...)
+			return
+		}
+	}
+}
+
+// diff returns the line based difference of a and b as the longest
+// common subsequence of their lines.
+func diff(a, b string) []DiffLine {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	// lcs[i][j] is the length of the LCS of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var d []DiffLine
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			d = append(d, DiffLine{" ", x[i]})
+			i++
+			j++
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			d = append(d, DiffLine{"-", x[i]})
+			i++
+		default:
+			d = append(d, DiffLine{"+", y[j]})
+			j++
+		}
+	}
+	return d
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		lifted bool
+		passes string
+		want   string // the function after the last pass
+	}{
+		{"constfold fraction", "func f() int {\n\tx := 2.5\n\tif int(x) == 2 {\n\t\treturn 1\n\t}\n\treturn 0\n}", true, "constfold",
+			"0: entry P:[] S:[1]\n\tjump 1\n1: if.then P:[0] S:[]\n\treturn 1:int\n2: if.done P:[] S:[]\n\treturn 0:int\n"},
+		{"constfold blockopt", "func f() int {\n\tx := 2.5\n\tif int(x) == 2 {\n\t\treturn 1\n\t}\n\treturn 0\n}", true, "constfold,blockopt",
+			"0: entry P:[] S:[]\n\treturn 1:int\n"},
+		{"copyprop", "func f(a int) int {\n\tx := a\n\ty := x\n\treturn y\n}", false, "copyprop",
+			"0: entry P:[] S:[]\n\tt0 = local int (a)\n\t*t0 = a\n\tt1 = local int (x)\n\t*t1 = a\n\tt3 = local int (y)\n\t*t3 = a\n\trundefers\n\treturn a\n"},
+		{"copyprop dce", "func f(a int) int {\n\tx := a\n\ty := x\n\treturn y\n}", false, "copyprop,dce",
+			"0: entry P:[] S:[]\n\trundefers\n\treturn a\n"},
+		{"phielim", "func f(c bool, a int) int {\n\tx := a\n\tif c {\n\t\tx = a\n\t}\n\treturn x\n}", true, "phielim",
+			"0: entry P:[] S:[1 2]\n\tif c goto 1 else 2\n1: if.then P:[0] S:[2]\n\tjump 2\n2: if.done P:[0 1] S:[]\n\treturn a\n"},
+		{"dce keeps division", "func f(n, d int) {\n\ty := n + 1\n\t_ = y\n\t_ = n / d\n}", true, "dce",
+			"0: entry P:[] S:[]\n\tt1 = n / d\n\treturn\n"},
+		{"dce keeps panicking instructions", "func f(n int, u uint, a, b interface{}, x, y int) {\n\t_ = 1 << n\n\t_ = 1 << u\n\t_ = a == b\n\t_ = x == y\n\t_ = make(map[int]int, n)\n\t_ = make(map[int]int, 8)\n}", true, "dce",
+			"0: entry P:[] S:[]\n\tt0 = convert uint64 <- int (n)\n\tt1 = 1:int << t0\n\tt3 = a == b\n\tt5 = make map[int]int n\n\treturn\n"},
+		// Threading the empty block 1 leaves an if with both edges to block 2.
+		{"blockopt degenerate if", "func f(c bool, n int) int {\n\tif c {\n\t\ty := n + 1\n\t\t_ = y\n\t}\n\treturn 1\n}", true, "dce,blockopt",
+			"0: entry P:[] S:[]\n\treturn 1:int\n"},
+		// The loop header has phis and two predecessors: nothing changes.
+		{"blockopt loop", "func f(n int) {\n\tfor n > 0 {\n\t\tn--\n\t}\n}", true, "blockopt",
+			"0: entry P:[] S:[3]\n\tjump 3\n1: for.body P:[3] S:[3]\n\tt0 = t1 - 1:int\n\tjump 3\n2: for.done P:[3] S:[]\n\treturn\n" +
+				"3: for.loop P:[0 1] S:[1 2]\n\tt1 = phi [0: n, 1: t0] #n\n\tt2 = t1 > 0:int\n\tif t2 goto 1 else 2\n"},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		if tt.lifted {
+			o["ssabuild"] = "true"
+		}
+		f := testPackageOptions(t, "package main\n\n"+tt.src+"\n", o).Func("f")
+		passes, err := parsePasses(tt.passes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, sizes, _ := o.target().context()
+		ps := optimize(f, passes, sizes)
+		if len(ps) != len(passes)+1 {
+			t.Fatalf("%s: %d stages, want %d", tt.name, len(ps), len(passes)+1)
+		}
+		if got := ps[len(ps)-1].Text; got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePasses(t *testing.T) {
+	if got, err := parsePasses("dce, blockopt constfold"); err != nil || !reflect.DeepEqual(got, []string{"dce", "blockopt", "constfold"}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	want := `unknown optimization pass "inline", want one of constfold, copyprop, phielim, dce, blockopt`
+	if _, err := parsePasses("dce,inline"); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := diff("a\nb\nc\n", "a\nx\nc\n")
+	want := []DiffLine{{" ", "a"}, {"-", "b"}, {"+", "x"}, {" ", "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// pointer is an inclusion-based (Andersen-style) pointer analysis over the
+// functions of a package and the functions of other packages they may
+// call.
+//
+// Abstract objects are allocation sites: Alloc, MakeMap, MakeSlice,
+// MakeChan, MakeInterface, MakeClosure, calls of append, globals and
+// functions.  The
+// analysis is flow- and context-insensitive and also field-insensitive:
+// all fields and elements of an object share one content node, which for
+// an interface object holds the dynamic value and for a closure object
+// its bindings.  Tuples are merged into one node as well.
+//
+// A function of a dependency is analyzed once a call to it is found.
+// Dependencies the builder does not support have no bodies, see buildDep:
+// the results of their functions point to nothing.
+type pointer struct {
+	pkg     *ssa.Package
+	funcs   map[*ssa.Function]bool
+	fs      []*ssa.Function
+	pts     map[ssa.Value]map[ssa.Value]bool // value -> objects
+	content map[ssa.Value]map[ssa.Value]bool // object -> objects
+	ret     map[*ssa.Function]map[ssa.Value]bool
+	callees map[ssa.CallInstruction]map[*ssa.Function]bool
+	changed bool
+}
+
+func init() {
+	register(pointsToAnalysis{})
+}
+
+// pointsToAnalysis shows the points-to sets of the values and the callees
+// of the dynamic calls of the package.
+type pointsToAnalysis struct{}
+
+func (pointsToAnalysis) Name() string { return "pointsto" }
+func (pointsToAnalysis) Doc() string {
+	return "show the allocation sites pointer-like values may point to and the functions dynamic calls may call"
+}
+
+func (pointsToAnalysis) Run(in *AnalysisInput) (*Report, error) {
+	pa, err := in.pointers()
+	if err != nil {
+		return nil, err
+	}
+	return &Report{PkgView: pa}, nil
+}
+
+// analyzePointers solves the constraints of all functions of pkg and of
+// the functions they call by repeatedly applying them until nothing
+// changes.  It stops with errDeadline once ctx is done.
+func analyzePointers(ctx context.Context, pkg *ssa.Package) (*pointer, error) {
+	p := &pointer{
+		pkg:     pkg,
+		funcs:   make(map[*ssa.Function]bool),
+		pts:     make(map[ssa.Value]map[ssa.Value]bool),
+		content: make(map[ssa.Value]map[ssa.Value]bool),
+		ret:     make(map[*ssa.Function]map[ssa.Value]bool),
+		callees: make(map[ssa.CallInstruction]map[*ssa.Function]bool),
+	}
+	for _, f := range pkgFuncs(pkg) {
+		p.add(f)
+	}
+	for p.changed = true; p.changed; {
+		p.changed = false
+		for k := 0; k < len(p.fs); k++ {
+			if ctx.Err() != nil {
+				return nil, errDeadline
+			}
+			f := p.fs[k]
+			for _, b := range f.Blocks {
+				for _, i := range b.Instrs {
+					p.instr(i)
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// add adds the function f to the analysis.
+func (p *pointer) add(f *ssa.Function) {
+	if !p.funcs[f] {
+		p.funcs[f] = true
+		p.fs = append(p.fs, f)
+		p.changed = true
+	}
+}
+
+// union adds the objects of from to the set to and records a change.
+func (p *pointer) union(to map[ssa.Value]bool, from map[ssa.Value]bool) {
+	for o := range from {
+		if !to[o] {
+			to[o] = true
+			p.changed = true
+		}
+	}
+}
+
+func (p *pointer) set(m map[ssa.Value]map[ssa.Value]bool, k ssa.Value) map[ssa.Value]bool {
+	s := m[k]
+	if s == nil {
+		s = make(map[ssa.Value]bool)
+		m[k] = s
+	}
+	return s
+}
+
+// of returns the points-to set of an operand.  Globals and functions
+// point to themselves.
+func (p *pointer) of(v ssa.Value) map[ssa.Value]bool {
+	switch v.(type) {
+	case *ssa.Global, *ssa.Function:
+		return map[ssa.Value]bool{v: true}
+	}
+	return p.pts[v]
+}
+
+// flow adds pts(from) to pts(to).
+func (p *pointer) flow(to, from ssa.Value) {
+	if from == nil {
+		return
+	}
+	p.union(p.set(p.pts, to), p.of(from))
+}
+
+// load adds the contents of every object pointed to by x to pts(v).
+func (p *pointer) load(v, x ssa.Value) {
+	for o := range p.of(x) {
+		p.union(p.set(p.pts, v), p.content[o])
+	}
+}
+
+// store adds pts(val) to the contents of every object pointed to by x.
+func (p *pointer) store(x, val ssa.Value) {
+	for o := range p.of(x) {
+		p.union(p.set(p.content, o), p.of(val))
+	}
+}
+
+func (p *pointer) instr(i ssa.Instruction) {
+	switch i := i.(type) {
+	case *ssa.Alloc, *ssa.MakeMap, *ssa.MakeSlice, *ssa.MakeChan:
+		v := i.(ssa.Value)
+		p.union(p.set(p.pts, v), map[ssa.Value]bool{v: true})
+	case *ssa.MakeInterface:
+		p.union(p.set(p.pts, i), map[ssa.Value]bool{i: true})
+		p.union(p.set(p.content, i), p.of(i.X))
+	case *ssa.MakeClosure:
+		p.union(p.set(p.pts, i), map[ssa.Value]bool{i: true})
+		fn := i.Fn.(*ssa.Function)
+		for k, b := range i.Bindings {
+			p.union(p.set(p.content, i), p.of(b))
+			p.flow(fn.FreeVars[k], b)
+		}
+	case *ssa.Store:
+		p.store(i.Addr, i.Val)
+	case *ssa.MapUpdate:
+		p.store(i.Map, i.Key)
+		p.store(i.Map, i.Value)
+	case *ssa.Send:
+		p.store(i.Chan, i.X)
+	case *ssa.UnOp:
+		if i.Op == token.MUL || i.Op == token.ARROW {
+			p.load(i, i.X)
+		}
+	case *ssa.Lookup:
+		if _, ok := i.X.Type().Underlying().(*types.Map); ok {
+			p.load(i, i.X)
+		} else {
+			p.flow(i, i.X)
+		}
+	case *ssa.Next:
+		p.load(i, i.Iter)
+	case *ssa.TypeAssert:
+		if types.IsInterface(i.AssertedType) {
+			p.flow(i, i.X)
+		} else {
+			p.load(i, i.X)
+		}
+	case *ssa.FieldAddr:
+		p.flow(i, i.X)
+	case *ssa.IndexAddr:
+		p.flow(i, i.X)
+	case *ssa.Field:
+		p.flow(i, i.X)
+	case *ssa.Index:
+		p.flow(i, i.X)
+	case *ssa.Range:
+		p.flow(i, i.X)
+	case *ssa.Slice:
+		p.flow(i, i.X)
+	case *ssa.ChangeType:
+		p.flow(i, i.X)
+	case *ssa.ChangeInterface:
+		p.flow(i, i.X)
+	case *ssa.Convert:
+		p.flow(i, i.X)
+	case *ssa.Extract:
+		p.flow(i, i.Tuple)
+	case *ssa.Phi:
+		for _, e := range i.Edges {
+			p.flow(i, e)
+		}
+	case *ssa.Select:
+		for _, st := range i.States {
+			if st.Dir == types.SendOnly {
+				p.store(st.Chan, st.Send)
+			} else {
+				p.load(i, st.Chan)
+			}
+		}
+	case *ssa.Return:
+		for _, r := range i.Results {
+			if p.ret[i.Parent()] == nil {
+				p.ret[i.Parent()] = make(map[ssa.Value]bool)
+			}
+			p.union(p.ret[i.Parent()], p.of(r))
+		}
+	case ssa.CallInstruction:
+		p.call(i)
+	}
+}
+
+// call links arguments to parameters and results to the call for all
+// possible callees of c.
+func (p *pointer) call(c ssa.CallInstruction) {
+	common := c.Common()
+	if b, ok := common.Value.(*ssa.Builtin); ok {
+		switch b.Name() {
+		case "append", "copy":
+			for o := range p.of(common.Args[0]) {
+				for e := range p.of(common.Args[1]) {
+					p.union(p.set(p.content, o), p.content[e])
+				}
+			}
+		}
+		// An append may allocate a new array, which holds the
+		// elements of both operands, e.g. when appending to a nil
+		// slice.
+		if v := c.Value(); v != nil && b.Name() == "append" {
+			p.union(p.set(p.pts, v), map[ssa.Value]bool{v: true})
+			p.flow(v, common.Args[0])
+			for _, a := range common.Args {
+				for o := range p.of(a) {
+					p.union(p.set(p.content, v), p.content[o])
+				}
+			}
+		}
+		return
+	}
+	if p.callees[c] == nil {
+		p.callees[c] = make(map[*ssa.Function]bool)
+	}
+	link := func(f *ssa.Function, recv ssa.Value, args []ssa.Value) {
+		if !p.callees[c][f] {
+			p.callees[c][f] = true
+			p.changed = true
+		}
+		if f.Blocks == nil {
+			return
+		}
+		p.add(f)
+		params := f.Params
+		if recv != nil && len(params) > 0 {
+			p.union(p.set(p.pts, params[0]), p.content[recv])
+			params = params[1:]
+		}
+		for k, a := range args {
+			if k < len(params) {
+				p.flow(params[k], a)
+			}
+		}
+		if v := c.Value(); v != nil {
+			p.union(p.set(p.pts, v), p.ret[f])
+		}
+	}
+	switch {
+	case common.IsInvoke():
+		for o := range p.of(common.Value) {
+			mi, ok := o.(*ssa.MakeInterface)
+			if !ok {
+				continue
+			}
+			prog := p.pkg.Prog
+			sel := prog.MethodSets.MethodSet(mi.X.Type()).Lookup(common.Method.Pkg(), common.Method.Name())
+			if sel == nil {
+				continue
+			}
+			if f := prog.MethodValue(sel); f != nil {
+				link(f, o, common.Args)
+			}
+		}
+	case common.StaticCallee() != nil:
+		link(common.StaticCallee(), nil, common.Args)
+	default:
+		for o := range p.of(common.Value) {
+			switch o := o.(type) {
+			case *ssa.Function:
+				link(o, nil, common.Args)
+			case *ssa.MakeClosure:
+				link(o.Fn.(*ssa.Function), nil, common.Args)
+			}
+		}
+	}
+}
+
+// pointsTo returns the labels of the objects v may point to, or nil if v
+// is not pointer-like or the analysis was not run.
+func (p *pointer) pointsTo(v ssa.Value) []string {
+	if p == nil {
+		return nil
+	}
+	// The iterator of a range has an opaque type without an underlying
+	// type.
+	if _, ok := v.(*ssa.Range); ok {
+		return nil
+	}
+	if !pointerLike(v.Type()) {
+		return nil
+	}
+	var ls []string
+	for o := range p.of(v) {
+		ls = append(ls, objLabel(p.pkg, o))
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+// calleesOf returns the functions a dynamic call site may call.
+func (p *pointer) calleesOf(i ssa.Instruction) []string {
+	c, ok := i.(ssa.CallInstruction)
+	if p == nil || !ok || c.Common().StaticCallee() != nil {
+		return nil
+	}
+	var cs []string
+	for f := range p.callees[c] {
+		cs = append(cs, f.RelString(p.pkg.Pkg))
+	}
+	sort.Strings(cs)
+	return cs
+}
+
+// pointerLike reports whether values of type t may hold pointers to
+// objects.
+func pointerLike(t types.Type) bool {
+	switch t := t.Underlying().(type) {
+	case *types.Pointer, *types.Map, *types.Slice, *types.Chan, *types.Interface, *types.Signature:
+		return true
+	case *types.Basic:
+		return t.Kind() == types.UnsafePointer
+	case *types.Struct:
+		for k := 0; k < t.NumFields(); k++ {
+			if pointerLike(t.Field(k).Type()) {
+				return true
+			}
+		}
+	case *types.Array:
+		return pointerLike(t.Elem())
+	case *types.Tuple:
+		for k := 0; k < t.Len(); k++ {
+			if pointerLike(t.At(k).Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// objLabel describes an allocation site.
+func objLabel(pkg *ssa.Package, o ssa.Value) string {
+	switch o := o.(type) {
+	case *ssa.Global:
+		return "global " + o.RelString(pkg.Pkg)
+	case *ssa.Function:
+		return "func " + o.RelString(pkg.Pkg)
+	}
+	l := o.Parent().RelString(pkg.Pkg) + "." + o.Name() + ": " + o.String()
+	if pos := posString(pkg.Prog, o.Pos()); pos != "" {
+		l += " at " + pos
+	}
+	return l
+}
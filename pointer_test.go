@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func TestAnalyzePointers(t *testing.T) {
+	src := `package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+type T struct{ p *int }
+
+func (t T) M() {}
+
+type I interface{ M() }
+
+func id(p *int) *int { return p }
+
+func f(r io.Reader) {
+	x := new(int)
+	y := id(x)
+	var i I = T{y}
+	i.M()
+	g := func() *int { return x }
+	println(g())
+	println(bufio.NewReader(r))
+	println(strings.NewReader("s"))
+}
+`
+	pkg := testPackage(t, src)
+	pa, err := analyzePointers(context.Background(), pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := pkg.Func("f")
+	// The values passed to println, in order, and the calls.
+	var args []ssa.Value
+	var calls []ssa.Instruction
+	for _, b := range f.Blocks {
+		for _, i := range b.Instrs {
+			c, ok := i.(*ssa.Call)
+			if !ok {
+				continue
+			}
+			calls = append(calls, c)
+			if b, ok := c.Call.Value.(*ssa.Builtin); ok && b.Name() == "println" {
+				args = append(args, c.Call.Args[0])
+			}
+		}
+	}
+	// The objects are named by function and instruction: the registers
+	// of the dependencies differ between releases of Go.
+	objects := func(v ssa.Value) []string {
+		var objs []string
+		for o := range pa.of(v) {
+			objs = append(objs, o.Parent().RelString(pkg.Pkg)+": "+o.String())
+		}
+		return objs
+	}
+	tests := []struct {
+		name string
+		v    ssa.Value
+		want []string
+	}{
+		{"closure result", args[0], []string{"f: new int (new)"}},
+		{"dependency result", args[1], []string{"bufio.NewReaderSize: new Reader (new)"}},
+		{"unbuilt dependency result", args[2], nil},
+	}
+	for _, tt := range tests {
+		if got := objects(tt.v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %s points to %v, want %v", tt.name, tt.v.Name(), got, tt.want)
+		}
+	}
+	var callees []string
+	for _, c := range calls {
+		callees = append(callees, pa.calleesOf(c)...)
+	}
+	if want := []string{"(T).M"}; !reflect.DeepEqual(callees, want) {
+		t.Errorf("callees %v, want %v", callees, want)
+	}
+	if !pa.funcs[pkg.Prog.ImportedPackage("bufio").Func("NewReaderSize")] {
+		t.Errorf("bufio.NewReaderSize is not analyzed")
+	}
+}
+
+func TestAnalyzePointersAppend(t *testing.T) {
+	src := `package main
+
+func f() *int {
+	x := 1
+	var s []*int
+	s = append(s, &x)
+	p := s[0]
+	return p
+}
+`
+	pkg := testPackage(t, src)
+	pa, err := analyzePointers(context.Background(), pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := pkg.Func("f")
+	var p ssa.Value
+	for _, b := range f.Blocks {
+		if r, ok := b.Instrs[len(b.Instrs)-1].(*ssa.Return); ok {
+			p = r.Results[0]
+		}
+	}
+	var objs []string
+	for o := range pa.of(p) {
+		objs = append(objs, o.String())
+	}
+	if want := []string{"new int (x)"}; !reflect.DeepEqual(objs, want) {
+		t.Errorf("p points to %v, want %v", objs, want)
+	}
+}
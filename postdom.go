@@ -0,0 +1,150 @@
+package main
+
+import (
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"postdom", "show the immediate post-dominator and the control dependences of every block", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return computePostDom(f)
+	}})
+}
+
+// Post-dominators of blocks which are not blocks of the function.
+const (
+	exitBlock = -1 // the virtual exit succeeding all Return and Panic blocks
+	noBlock   = -2 // no post-dominator since the exit can not be reached
+)
+
+// CtrlDep tells that a block only runs if the If instruction ending
+// Block takes the Branch.
+type CtrlDep struct {
+	Block  int
+	ID     string
+	Branch bool
+}
+
+// postDom holds the immediate post-dominator of every block of a
+// function.  The virtual exit is the node len(f.Blocks).
+type postDom struct {
+	f     *ssa.Function
+	ipdom []int
+}
+
+// computePostDom computes the post-dominator tree as the dominator tree of
+// the reversed CFG with the iterative algorithm of Cooper, Harvey and
+// Kennedy.  Blocks from which the exit can not be reached, e.g. those of
+// an infinite loop, have no post-dominator.
+func computePostDom(f *ssa.Function) *postDom {
+	n := len(f.Blocks)
+	exit := n
+	// succs and preds of the reversed CFG.
+	succs := make([][]int, n+1)
+	preds := make([][]int, n+1)
+	for _, b := range f.Blocks {
+		for _, p := range b.Preds {
+			succs[b.Index] = append(succs[b.Index], p.Index)
+		}
+		for _, s := range b.Succs {
+			preds[b.Index] = append(preds[b.Index], s.Index)
+		}
+		if len(b.Instrs) == 0 {
+			continue
+		}
+		switch b.Instrs[len(b.Instrs)-1].(type) {
+		case *ssa.Return, *ssa.Panic:
+			succs[exit] = append(succs[exit], b.Index)
+			preds[b.Index] = append(preds[b.Index], exit)
+		}
+	}
+
+	order := make([]int, n+1) // postorder number plus one; 0 if unreached
+	var post []int
+	var dfs func(v int)
+	dfs = func(v int) {
+		order[v] = -1
+		for _, w := range succs[v] {
+			if order[w] == 0 {
+				dfs(w)
+			}
+		}
+		post = append(post, v)
+		order[v] = len(post)
+	}
+	dfs(exit)
+
+	ipdom := make([]int, n+1)
+	for i := range ipdom {
+		ipdom[i] = noBlock
+	}
+	ipdom[exit] = exit
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = ipdom[a]
+			}
+			for order[b] < order[a] {
+				b = ipdom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for k := len(post) - 2; k >= 0; k-- {
+			v := post[k]
+			idom := noBlock
+			for _, p := range preds[v] {
+				if ipdom[p] == noBlock {
+					continue
+				}
+				if idom == noBlock {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if ipdom[v] != idom {
+				ipdom[v] = idom
+				changed = true
+			}
+		}
+	}
+	return &postDom{f, ipdom}
+}
+
+// idom returns the index of the immediate post-dominator of b, which may
+// be exitBlock or noBlock.
+func (pd *postDom) idom(b *ssa.BasicBlock) int {
+	switch d := pd.ipdom[b.Index]; d {
+	case len(pd.f.Blocks):
+		return exitBlock
+	default:
+		return d
+	}
+}
+
+// controlDeps derives the control dependences from the post-dominator
+// tree: for every edge a->b where b does not post-dominate a, all blocks
+// on the path from b up the tree to the immediate post-dominator of a
+// (exclusively) are control dependent on the If ending a.
+func (pd *postDom) controlDeps(du *defUse) map[*ssa.BasicBlock][]CtrlDep {
+	deps := make(map[*ssa.BasicBlock][]CtrlDep)
+	for _, a := range pd.f.Blocks {
+		if len(a.Instrs) == 0 || pd.ipdom[a.Index] == noBlock {
+			continue
+		}
+		i, ok := a.Instrs[len(a.Instrs)-1].(*ssa.If)
+		if !ok {
+			continue
+		}
+		stop := pd.ipdom[a.Index]
+		for k, b := range a.Succs {
+			for v := b.Index; v != stop && v != noBlock && v != len(pd.f.Blocks); v = pd.ipdom[v] {
+				c := pd.f.Blocks[v]
+				deps[c] = append(deps[c], CtrlDep{a.Index, du.id(i), k == 0})
+			}
+		}
+	}
+	return deps
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const postDomSrc = `package main
+
+func f(a, b bool) int {
+	x := 0
+	if a {
+		x = 1
+		if b {
+			x = 2
+		}
+	} else {
+		x = 3
+	}
+	return x
+}
+
+func g(a bool) {
+	if a {
+		for {
+			println()
+		}
+	}
+}
+
+func h(a bool) {
+	if a {
+		panic("a")
+	}
+	println()
+}
+`
+
+func TestPostDom(t *testing.T) {
+	tests := []struct {
+		name string
+		idom []int
+		deps []string // block: dependences
+	}{
+		// 0: if a goto 1 else 3; 1: if b goto 4 else 2; 2: return; 3, 4: jump 2
+		{"f", []int{2, 2, exitBlock, 2, 2}, []string{"1: [{0 f-b0-0 true}]", "3: [{0 f-b0-0 false}]", "4: [{1 f-b1-0 true}]"}},
+		// 0: if a goto 2 else 1; 1: return; 2: jump 2
+		{"g", []int{1, exitBlock, noBlock}, []string{"2: [{0 g-b0-0 true}]"}},
+		// 0: if a goto 1 else 2; 1: panic; 2: return
+		{"h", []int{exitBlock, exitBlock, exitBlock}, []string{"1: [{0 h-b0-0 true}]", "2: [{0 h-b0-0 false}]"}},
+	}
+	pkg := testPackage(t, postDomSrc)
+	for _, tt := range tests {
+		f := pkg.Func(tt.name)
+		pd := computePostDom(f)
+		var idom []int
+		for _, b := range f.Blocks {
+			idom = append(idom, pd.idom(b))
+		}
+		if !reflect.DeepEqual(idom, tt.idom) {
+			t.Errorf("%s: post-dominators %v, want %v", tt.name, idom, tt.idom)
+		}
+		ctrl := pd.controlDeps(newDefUse(f))
+		var deps []string
+		for _, b := range f.Blocks {
+			if ctrl[b] != nil {
+				deps = append(deps, fmt.Sprintf("%d: %v", b.Index, ctrl[b]))
+			}
+		}
+		if !reflect.DeepEqual(deps, tt.deps) {
+			t.Errorf("%s: control dependences %q, want %q", tt.name, deps, tt.deps)
+		}
+	}
+}
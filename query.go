@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// QueryResult is an instruction matched by a query.
+type QueryResult struct {
+	Func  string
+	Block int
+	ID    string
+	Instr string
+	Pos   string
+}
+
+// A query selects instructions:
+//
+//	query := kind {flag} [where cond {and cond}] {in (func glob | block n)}
+//	cond  := field [type] (is word | matches "glob" | = "text")
+//
+// The kind is the lower case name of an ssa instruction type, e.g. store
+// or call, load or recv for the loads and receives of UnOp, or any.
+// Fields are callee, type, op and name of the instruction, and addr and
+// operand, whose values can be tested for their kind with is, matched by
+// name or, followed by type, matched by their type.  In a glob, * matches
+// any text and ? a single character.
+type query struct {
+	kind  string
+	flags []string
+	conds []cond
+	fn    *regexp.Regexp // nil for any function
+	block int
+}
+
+type cond struct {
+	field string
+	typ   bool // test the type of the value of field
+	op    string
+	arg   string
+	glob  *regexp.Regexp // the compiled arg of matches
+}
+
+var queryKinds = map[string]bool{
+	"any": true, "alloc": true, "binop": true, "call": true, "changeinterface": true, "changetype": true,
+	"convert": true, "debugref": true, "defer": true, "extract": true, "field": true, "fieldaddr": true,
+	"go": true, "if": true, "index": true, "indexaddr": true, "jump": true, "load": true, "lookup": true,
+	"makechan": true, "makeclosure": true, "makeinterface": true, "makemap": true, "makeslice": true,
+	"mapupdate": true, "next": true, "panic": true, "phi": true, "range": true, "recv": true, "return": true,
+	"rundefers": true, "select": true, "send": true, "slice": true, "store": true, "typeassert": true, "unop": true,
+}
+
+// queryFlags test an instruction for a property.
+var queryFlags = map[string]func(ssa.Instruction) bool{
+	"heap": func(i ssa.Instruction) bool {
+		a, ok := i.(*ssa.Alloc)
+		return ok && a.Heap
+	},
+	"local": func(i ssa.Instruction) bool {
+		a, ok := i.(*ssa.Alloc)
+		return ok && !a.Heap
+	},
+	"static": func(i ssa.Instruction) bool {
+		c, ok := i.(ssa.CallInstruction)
+		return ok && c.Common().StaticCallee() != nil
+	},
+	"dynamic": func(i ssa.Instruction) bool {
+		c, ok := i.(ssa.CallInstruction)
+		if !ok || c.Common().IsInvoke() || c.Common().StaticCallee() != nil {
+			return false
+		}
+		_, builtin := c.Common().Value.(*ssa.Builtin)
+		return !builtin
+	},
+	"invoke": func(i ssa.Instruction) bool {
+		c, ok := i.(ssa.CallInstruction)
+		return ok && c.Common().IsInvoke()
+	},
+	"builtin": func(i ssa.Instruction) bool {
+		c, ok := i.(ssa.CallInstruction)
+		if !ok {
+			return false
+		}
+		_, builtin := c.Common().Value.(*ssa.Builtin)
+		return builtin
+	},
+	"commaok": func(i ssa.Instruction) bool {
+		switch i := i.(type) {
+		case *ssa.TypeAssert:
+			return i.CommaOk
+		case *ssa.Lookup:
+			return i.CommaOk
+		case *ssa.UnOp:
+			return i.CommaOk
+		}
+		return false
+	},
+}
+
+// valueKinds classify the values of the addr and operand fields.
+var valueKinds = map[string]func(ssa.Value) bool{
+	"global":  func(v ssa.Value) bool { _, ok := v.(*ssa.Global); return ok },
+	"param":   func(v ssa.Value) bool { _, ok := v.(*ssa.Parameter); return ok },
+	"freevar": func(v ssa.Value) bool { _, ok := v.(*ssa.FreeVar); return ok },
+	"const":   func(v ssa.Value) bool { _, ok := v.(*ssa.Const); return ok },
+	"func":    func(v ssa.Value) bool { _, ok := v.(*ssa.Function); return ok },
+	"builtin": func(v ssa.Value) bool { _, ok := v.(*ssa.Builtin); return ok },
+	"alloc":   func(v ssa.Value) bool { _, ok := v.(*ssa.Alloc); return ok },
+	"heap":    func(v ssa.Value) bool { a, ok := v.(*ssa.Alloc); return ok && a.Heap },
+	"local":   func(v ssa.Value) bool { a, ok := v.(*ssa.Alloc); return ok && !a.Heap },
+	"register": func(v ssa.Value) bool {
+		_, ok := v.(ssa.Instruction)
+		return ok
+	},
+}
+
+// lexQuery splits a query into words, quoted strings and =.
+func lexQuery(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("query: unterminated string %s", s[i:])
+			}
+			u, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("query: bad string %s", s[i:j+1])
+			}
+			toks = append(toks, u)
+			i = j + 1
+		case c == '=':
+			toks = append(toks, "=")
+			i++
+		default:
+			j := i
+			for j < len(s) {
+				c, size := utf8.DecodeRuneInString(s[j:])
+				if unicode.IsSpace(c) || c == '"' || c == '=' {
+					break
+				}
+				j += size
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func parseQuery(s string) (*query, error) {
+	toks, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("query: missing instruction kind")
+	}
+	q := &query{kind: strings.ToLower(toks[0]), block: -1}
+	if !queryKinds[q.kind] {
+		return nil, fmt.Errorf("query: unknown instruction kind %q", toks[0])
+	}
+	toks = toks[1:]
+	next := func() string {
+		if len(toks) == 0 {
+			return ""
+		}
+		t := toks[0]
+		toks = toks[1:]
+		return t
+	}
+	for len(toks) > 0 && toks[0] != "where" && toks[0] != "in" {
+		f := next()
+		if queryFlags[f] == nil {
+			return nil, fmt.Errorf("query: unknown flag %q", f)
+		}
+		q.flags = append(q.flags, f)
+	}
+	if len(toks) > 0 && toks[0] == "where" {
+		next()
+		for {
+			c := cond{field: next()}
+			switch c.field {
+			case "callee", "type", "op", "name":
+			case "addr", "operand":
+				if len(toks) > 0 && toks[0] == "type" {
+					next()
+					c.typ = true
+				}
+			default:
+				return nil, fmt.Errorf("query: unknown field %q", c.field)
+			}
+			c.op, c.arg = next(), next()
+			switch c.op {
+			case "is", "matches", "=":
+			default:
+				return nil, fmt.Errorf("query: want is, matches or = after %s, got %q", c.field, c.op)
+			}
+			if c.op == "matches" {
+				c.glob = compileGlob(c.arg)
+			}
+			if c.op == "is" && (c.field == "addr" || c.field == "operand") && !c.typ && valueKinds[c.arg] == nil {
+				return nil, fmt.Errorf("query: unknown value kind %q", c.arg)
+			}
+			q.conds = append(q.conds, c)
+			if len(toks) == 0 || toks[0] != "and" {
+				break
+			}
+			next()
+		}
+	}
+	for len(toks) > 0 {
+		if w := next(); w != "in" {
+			return nil, fmt.Errorf("query: want in, got %q", w)
+		}
+		w, arg := next(), next()
+		if arg == "" {
+			return nil, fmt.Errorf("query: missing argument of in %s", w)
+		}
+		switch w {
+		case "func":
+			q.fn = compileGlob(arg)
+		case "block":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, fmt.Errorf("query: bad block %q", arg)
+			}
+			q.block = n
+		default:
+			return nil, fmt.Errorf("query: want in func or in block, got in %q", w)
+		}
+	}
+	return q, nil
+}
+
+// compileGlob returns a regexp matching the whole text against a pattern
+// in which * matches any text and ? a single character.
+func compileGlob(pattern string) *regexp.Regexp {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\*`, ".*", -1)
+	re = strings.Replace(re, `\?`, ".", -1)
+	return regexp.MustCompile("^" + re + "$")
+}
+
+// instrKind returns the kind of i as used in queries.
+func instrKind(i ssa.Instruction) string {
+	return strings.ToLower(reflect.TypeOf(i).Elem().Name())
+}
+
+func (q *query) match(i ssa.Instruction, pkg *types.Package) bool {
+	switch q.kind {
+	case "any":
+	case "load", "recv":
+		op := token.MUL
+		if q.kind == "recv" {
+			op = token.ARROW
+		}
+		if u, ok := i.(*ssa.UnOp); !ok || u.Op != op {
+			return false
+		}
+	default:
+		if instrKind(i) != q.kind {
+			return false
+		}
+	}
+	for _, f := range q.flags {
+		if !queryFlags[f](i) {
+			return false
+		}
+	}
+	if q.fn != nil && !q.fn.MatchString(i.Parent().Name()) {
+		return false
+	}
+	if q.block >= 0 && i.Block().Index != q.block {
+		return false
+	}
+	for _, c := range q.conds {
+		if !c.match(i, pkg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c cond) match(i ssa.Instruction, pkg *types.Package) bool {
+	qual := types.RelativeTo(pkg)
+	text := func(s string) bool {
+		switch c.op {
+		case "matches":
+			return c.glob.MatchString(s)
+		default:
+			return s == c.arg
+		}
+	}
+	switch c.field {
+	case "callee":
+		call, ok := i.(ssa.CallInstruction)
+		return ok && text(calleeName(call.Common(), pkg))
+	case "type":
+		v, ok := i.(ssa.Value)
+		return ok && text(types.TypeString(v.Type(), qual))
+	case "op":
+		switch i := i.(type) {
+		case *ssa.BinOp:
+			return text(i.Op.String())
+		case *ssa.UnOp:
+			return text(i.Op.String())
+		}
+		return false
+	case "name":
+		v, ok := i.(ssa.Value)
+		return ok && text(v.Name())
+	}
+
+	var vs []ssa.Value
+	if c.field == "addr" {
+		switch i := i.(type) {
+		case *ssa.Store:
+			vs = append(vs, i.Addr)
+		case *ssa.UnOp:
+			if i.Op == token.MUL {
+				vs = append(vs, i.X)
+			}
+		}
+	} else {
+		for _, op := range i.Operands(nil) {
+			if *op != nil {
+				vs = append(vs, *op)
+			}
+		}
+	}
+	for _, v := range vs {
+		switch {
+		case c.typ:
+			if text(types.TypeString(v.Type(), qual)) {
+				return true
+			}
+		case c.op == "is":
+			if valueKinds[c.arg](v) {
+				return true
+			}
+		case text(v.Name()):
+			return true
+		}
+	}
+	return false
+}
+
+// runQuery returns the instructions of pkg matched by the query s.
+func runQuery(pkg *ssa.Package, s string) ([]QueryResult, error) {
+	q, err := parseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	var rs []QueryResult
+	for _, f := range pkgFuncs(pkg) {
+		du := newDefUse(f)
+		for _, b := range f.Blocks {
+			for _, i := range b.Instrs {
+				if !q.match(i, pkg.Pkg) {
+					continue
+				}
+				text := i.String()
+				if v, ok := i.(ssa.Value); ok {
+					text = v.Name() + " = " + text
+				}
+				rs = append(rs, QueryResult{f.RelString(pkg.Pkg), b.Index, du.id(i), text, posString(pkg.Prog, instrPos(i))})
+			}
+		}
+	}
+	return rs, nil
+}
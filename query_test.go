@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunQuery(t *testing.T) {
+	const src = `package main
+
+import "fmt"
+
+var g int
+
+type T struct{ n int }
+
+func (t *T) get() int { return t.n }
+
+func f(p *T, c chan int) {
+	g = p.get()
+	x := new(int)
+	*x = <-c
+	fmt.Println(x)
+	v, ok := interface{}(g).(int)
+	println(v, ok)
+}
+
+func main() {
+	f(&T{}, make(chan int))
+}
+`
+	pkg := testPackage(t, src)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"store where addr is global", []string{"init 1: *init$guard = true:bool", "f 0: *g = t0"}},
+		{`call where callee matches "fmt.*" in func f`, []string{"f 0: t7 = fmt.Println(t6...)"}},
+		{"alloc heap in func main", []string{"main 0: t0 = new T (complit)"}},
+		{"recv", []string{"f 0: t2 = <-c"}},
+		{"load in func ?et", []string{"(*T).get 0: t1 = *t0"}},
+		{"call builtin", []string{"f 0: t13 = println(t11, t12)"}},
+		{"typeassert commaok", []string{"f 0: t10 = typeassert,ok t9.(int)"}},
+		{`store where addr type = "*int" and operand is register`, []string{"f 0: *g = t0", "f 0: *t1 = t2"}},
+		{`call where callee = "(*T).get"`, []string{"f 0: t0 = (*T).get(p)"}},
+		{`makechan where name = "t1"`, []string{"main 0: t1 = make chan int 0:int"}},
+		{"any in func get in block 0", []string{"(*T).get 0: t0 = &t.n [#0]", "(*T).get 0: t1 = *t0", "(*T).get 0: return t1"}},
+		{"any in func get in block 1", nil},
+	}
+	for _, tt := range tests {
+		rs, err := runQuery(pkg, tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, r := range rs {
+			got = append(got, fmt.Sprintf("%s %d: %s", r.Func, r.Block, r.Instr))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.query, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// TestLexQuery checks that the words are split at spaces, not at the bytes
+// of other characters, such as the 0x85 of ƅ.
+func TestLexQuery(t *testing.T) {
+	got, err := lexQuery("call where name matches \"ƅ*\" and name = xƅ\u00a0in func ƅ")
+	want := []string{"call", "where", "name", "matches", "ƅ*", "and", "name", "=", "xƅ", "in", "func", "ƅ"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query, err string
+	}{
+		{"", "query: missing instruction kind"},
+		{"jmp", `query: unknown instruction kind "jmp"`},
+		{"alloc big", `query: unknown flag "big"`},
+		{"call where size is 1", `query: unknown field "size"`},
+		{"call where callee has f", `query: want is, matches or = after callee, got "has"`},
+		{"store where addr is nowhere", `query: unknown value kind "nowhere"`},
+		{`call where callee matches "f`, `query: unterminated string "f`},
+		{"call in block x", `query: bad block "x"`},
+		{"call in func", "query: missing argument of in func"},
+		{"call in loop 1", `query: want in func or in block, got in "loop"`},
+		{"call where name = t0 or", `query: want in, got "or"`},
+	}
+	for _, tt := range tests {
+		_, err := parseQuery(tt.query)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: error %v, want %s", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"fmt.*", "fmt.Println", true},
+		{"fmt.*", "xfmt.Println", false},
+		{"f?", "fn", true},
+		{"f?", "f", false},
+		{"(*T).get", "(*T).get", true},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		if got := compileGlob(tt.pattern).MatchString(tt.s); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// results caches the rendered SSA of the server by a hash of the request,
+// see resultKey: permalinks, examples and repeated renders are built once.
+var results = &resultCache{max: 128, files: 10000, entries: make(map[string]*list.Element), lru: list.New()}
+
+// resultCache keeps the most recently used results in memory and, if it
+// has a directory, more of them on disk, which survive restarts of the
+// same server binary.
+type resultCache struct {
+	mu       sync.Mutex
+	max      int      // RESULT_CACHE_SIZE: results in memory
+	files    int      // RESULT_CACHE_FILES: results on disk
+	disk     *diskLRU // in RESULT_CACHE_DIR, if any
+	salt     string   // hash of the server binary
+	token    string   // CACHE_TOKEN: authorizes clearing the cache
+	lru      *list.List
+	entries  map[string]*list.Element
+	hits     int64
+	diskHits int64
+	misses   int64
+}
+
+type resultEntry struct {
+	key string
+	ssa SSA
+}
+
+// ResultStats are the counters of the result cache.
+type ResultStats struct {
+	Hits     int64 // results found in memory
+	DiskHits int64 // results found on disk
+	Misses   int64 // results built
+	Entries  int   // results in memory
+}
+
+// loadResults configures the result cache from the environment.  Keys
+// include a hash of the server binary, so that results and ETags of
+// another version are not reused.
+func loadResults() error {
+	for _, v := range []struct {
+		name string
+		n    *int
+	}{{"RESULT_CACHE_SIZE", &results.max}, {"RESULT_CACHE_FILES", &results.files}} {
+		if s := os.Getenv(v.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return fmt.Errorf("bad %s %q, want a number", v.name, s)
+			}
+			*v.n = n
+		}
+	}
+	results.token = os.Getenv("CACHE_TOKEN")
+	if dir := os.Getenv("RESULT_CACHE_DIR"); dir != "" {
+		if err := results.open(dir); err != nil {
+			return err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	results.salt = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// resultOptions are the options the rendered SSA depends on.
+var resultOptions = []string{"ssabuild", "run", "passes", "query", "taint", "funcs", "pkg", "file"}
+
+// resultKey returns the hash of the render of the request: its options,
+// its build context, the limits of the builds and the Go files it builds,
+// with line endings normalized.  The sources of an import path are read
+// from disk, if it may be imported; those of the dependencies are not
+// hashed.  It returns the empty string if the request is bad, which the
+// build then reports.
+func resultKey(r *http.Request, o options) string {
+	ctxt, _, err := o.target().context()
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", results.salt, contextKey(ctxt))
+	fmt.Fprintf(h, "packages %d imports %q timeout %v\n", limits.packages, limits.imports, limits.timeout)
+	for _, k := range resultOptions {
+		fmt.Fprintf(h, "%s %q\n", k, o[k])
+	}
+	file := func(name string, data []byte) {
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		fmt.Fprintf(h, "%s %d\n%s", name, len(data), data)
+	}
+	src := []byte(r.PostFormValue("source"))
+	if path := strings.TrimSpace(r.PostFormValue("pkg")); path != "" {
+		if !importAllowed(ctxt, path) {
+			return ""
+		}
+		dir, names, err := pkgFiles(ctxt, path)
+		if err != nil {
+			return ""
+		}
+		for _, n := range names {
+			data := src
+			if n != r.PostFormValue("file") {
+				if data, err = ioutil.ReadFile(filepath.Join(dir, n)); err != nil {
+					return ""
+				}
+			}
+			file(n, data)
+		}
+	} else {
+		archive, err := parseArchive(src, "main.go")
+		if err != nil {
+			return ""
+		}
+		for _, af := range archive {
+			if strings.HasSuffix(af.Name, ".go") {
+				file(af.Name, af.Data)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the result of key from memory or disk.
+func (c *resultCache) get(key string) (SSA, bool) {
+	if key == "" {
+		return SSA{}, false
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*resultEntry).ssa, true
+	}
+	c.mu.Unlock()
+	if c.disk != nil {
+		if b, err := c.disk.read(key); err == nil {
+			var s SSA
+			if gob.NewDecoder(bytes.NewReader(b)).Decode(&s) == nil {
+				c.mu.Lock()
+				c.diskHits++
+				c.add(key, s)
+				c.mu.Unlock()
+				return s, true
+			}
+		}
+	}
+	c.mu.Lock()
+	c.misses++
+	c.mu.Unlock()
+	return SSA{}, false
+}
+
+// put stores the result of key, in memory and on disk.
+func (c *resultCache) put(key string, s SSA) {
+	if key == "" {
+		return
+	}
+	c.mu.Lock()
+	c.add(key, s)
+	c.mu.Unlock()
+	if c.disk == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if gob.NewEncoder(&buf).Encode(s) == nil {
+		c.disk.write(key, buf.Bytes())
+	}
+}
+
+// add puts the result of key in memory, dropping the least recently
+// used results beyond the maximum.
+func (c *resultCache) add(key string, s SSA) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&resultEntry{key, s})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*resultEntry).key)
+	}
+}
+
+// open keeps the results on disk in dir, removing the least recently
+// used ones beyond the maximum.
+func (c *resultCache) open(dir string) error {
+	l, err := newDiskLRU(dir, ".gob", c.files)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.disk = l
+	c.mu.Unlock()
+	return nil
+}
+
+// authorized reports whether r bears the token of c in its Authorization
+// header.  Without a token nobody is.
+func (c *resultCache) authorized(r *http.Request) bool {
+	got := []byte(r.Header.Get("Authorization"))
+	return c.token != "" && subtle.ConstantTimeCompare(got, []byte("Bearer "+c.token)) == 1
+}
+
+// clear drops all results, in memory and on disk.
+func (c *resultCache) clear() error {
+	c.mu.Lock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.mu.Unlock()
+	if c.disk == nil {
+		return nil
+	}
+	return c.disk.clear()
+}
+
+func (c *resultCache) stats() ResultStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ResultStats{c.hits, c.diskHits, c.misses, c.lru.Len()}
+}
+
+// etag returns the entity tag of the response of the API to the request
+// with the result key, or the empty string if it has none.  The tag
+// depends on the endpoint and, for points-to sets, on the value.
+func etag(r *http.Request, key string) string {
+	if key == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(key + "\n" + r.URL.Path + "\n" + r.PostFormValue("value")))
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// notModified answers 304 Not Modified if the request has the response
+// with the tag already.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			w.Header().Set("ETag", tag)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/list"
+	"go/build"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testResults replaces the result cache by an empty one keeping max
+// results in memory and files in dir, if any, until the returned function
+// is called.
+func testResults(t *testing.T, max, files int, dir string) func() {
+	old := results
+	results = &resultCache{max: max, files: files, entries: make(map[string]*list.Element), lru: list.New()}
+	if dir != "" {
+		if err := results.open(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() { results = old }
+}
+
+func TestResultKey(t *testing.T) {
+	defer testGOPATH(t, testLib)()
+	defer func(imports []string, n int) { limits.imports, limits.packages = imports, n }(limits.imports, limits.packages)
+	key := func(form url.Values) string {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		o := newOptions()
+		setOptions(o, r)
+		return resultKey(r, o)
+	}
+	const src = "package main\n\nfunc main() {}\n"
+	base := url.Values{"source": {src}}
+	lib := url.Values{"pkg": {"example.com/lib"}}
+	tests := []struct {
+		name  string
+		form  url.Values
+		setup func()
+		want  string // same, other or empty, compared to the key of base
+	}{
+		{"same", base, nil, "same"},
+		{"line endings", url.Values{"source": {strings.Replace(src, "\n", "\r\n", -1)}}, nil, "same"},
+		{"source", url.Values{"source": {src + "\nfunc f() {}\n"}}, nil, "other"},
+		{"other files", url.Values{"source": {"-- a.txt --\nx\n-- main.go --\n" + src}}, nil, "same"},
+		{"option", url.Values{"source": {src}, "run": {"none"}}, nil, "other"},
+		{"target", url.Values{"source": {src}, "goos": {"windows"}}, nil, "other"},
+		{"bad target", url.Values{"source": {src}, "goos": {"mars"}}, nil, "empty"},
+		{"packages limit", base, func() { limits.packages = 1 }, "other"},
+		{"imports limit", base, func() { limits.imports = []string{"std"} }, "other"},
+		{"package", lib, nil, "other"},
+		{"package not allowed", lib, func() { limits.imports = []string{"std"} }, "empty"},
+		{"no package", url.Values{"pkg": {"no/such/package"}}, nil, "empty"},
+	}
+	limits.imports, limits.packages = nil, 300
+	want := key(base)
+	for _, tt := range tests {
+		limits.imports, limits.packages = nil, 300
+		if tt.setup != nil {
+			tt.setup()
+		}
+		got := key(tt.form)
+		switch {
+		case tt.want == "empty" && got != "",
+			tt.want == "same" && got != want,
+			tt.want == "other" && (got == "" || got == want):
+			t.Errorf("%s: key %q, want %s than %q", tt.name, got, tt.want, want)
+		}
+	}
+	// The files of a package are read from disk.
+	limits.imports = nil
+	before := key(lib)
+	name := filepath.Join(build.Default.GOPATH, "src", "example.com", "lib", "lib.go")
+	if err := ioutil.WriteFile(name, []byte(testLib["example.com/lib/lib.go"]+"\nfunc g() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after := key(lib); after == before {
+		t.Errorf("key %q of an edited package unchanged", after)
+	}
+}
+
+func TestResultCacheLRU(t *testing.T) {
+	defer testResults(t, 2, 0, "")()
+	ssa := func(name string) SSA { return SSA{Funcs: []Func{{Name: name}}} }
+	steps := []struct {
+		op, key string
+		hit     bool // for get
+	}{
+		{"put", "a", false},
+		{"put", "b", false},
+		{"get", "a", true},
+		{"put", "c", false}, // evicts b, the least recently used
+		{"get", "b", false},
+		{"get", "a", true},
+		{"get", "c", true},
+		{"get", "", false},
+	}
+	for k, s := range steps {
+		switch s.op {
+		case "put":
+			results.put(s.key, ssa(s.key))
+		case "get":
+			got, ok := results.get(s.key)
+			if ok != s.hit || ok && got.Funcs[0].Name != s.key {
+				t.Errorf("%d: get %q = %v, %v, want hit %v", k, s.key, got.Funcs, ok, s.hit)
+			}
+		}
+	}
+	if st := results.stats(); st.Hits != 3 || st.Misses != 1 || st.Entries != 2 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestResultCacheDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gobs := func() int {
+		fs, _ := filepath.Glob(filepath.Join(dir, "*.gob"))
+		return len(fs)
+	}
+	restore := testResults(t, 1, 2, dir)
+	defer restore()
+	// File times may be coarse; wait for them to differ.
+	for _, key := range []string{"a", "b"} {
+		results.put(key, SSA{})
+		time.Sleep(20 * time.Millisecond)
+	}
+	// A hit on disk is a use: b is evicted, not a.
+	if _, ok := results.get("a"); !ok {
+		t.Fatal("a not on disk")
+	}
+	time.Sleep(20 * time.Millisecond)
+	results.put("c", SSA{})
+	if _, err := os.Stat(results.disk.file("b")); !os.IsNotExist(err) {
+		t.Errorf("b kept: %v", err)
+	}
+	if n := gobs(); n != 2 {
+		t.Errorf("%d files, want 2", n)
+	}
+	// A cache opened on a full directory evicts down to its maximum.
+	testResults(t, 1, 1, dir)
+	if n := gobs(); n != 1 {
+		t.Errorf("%d files, want 1", n)
+	}
+	if _, ok := results.get("c"); !ok {
+		t.Error("c, the most recently used, evicted")
+	}
+	if err := results.clear(); err != nil {
+		t.Fatal(err)
+	}
+	if n := gobs(); n != 0 || results.disk.n != 0 {
+		t.Errorf("%d files, %d counted after clear", n, results.disk.n)
+	}
+}
+
+func TestCacheHandlerDelete(t *testing.T) {
+	defer testResults(t, 2, 0, "")()
+	tests := []struct {
+		token, auth string
+		status      int
+	}{
+		{"", "", 403},
+		{"", "Bearer ", 403},
+		{"secret", "", 403},
+		{"secret", "Bearer other", 403},
+		{"secret", "Bearer secret", 200},
+	}
+	for _, tt := range tests {
+		results.token = tt.token
+		results.put("a", SSA{})
+		r := httptest.NewRequest("DELETE", "/api/cache", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		cacheHandler(w, r)
+		_, kept := results.get("a")
+		if w.Code != tt.status || kept != (tt.status != 200) {
+			t.Errorf("token %q, %q: status %d, kept %v, want %d", tt.token, tt.auth, w.Code, kept, tt.status)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+func init() {
+	register(viewAnalysis{"constprop", "fold constants, list constant branches and grey out unreachable blocks", func(in *AnalysisInput, f *ssa.Function) interface{} {
+		return computeSCCP(f, in.Sizes)
+	}})
+}
+
+// Lattice states of the constant propagation.
+const (
+	undefined   = iota // no value seen yet
+	constVal           // exactly one constant value
+	overdefined        // not a constant
+)
+
+type lattice struct {
+	state int
+	val   constant.Value
+}
+
+// Branch is an If instruction whose condition is always true or always
+// false.
+type Branch struct {
+	ID     string
+	Block  int
+	Cond   string
+	Always bool
+	Pos    string
+}
+
+// sccp is the result of the sparse conditional constant propagation of a
+// function as described by Wegman and Zadeck.
+type sccp struct {
+	values map[ssa.Value]lattice
+	exec   map[*ssa.BasicBlock]bool
+	sizes  types.Sizes // of the target, for overflow checks
+}
+
+// computeSCCP propagates constants through BinOp, UnOp, Convert and Phi
+// instructions, only following CFG edges which may be taken.
+// It is iterated until a fixpoint is reached; the lattice values only
+// ever move from undefined over constVal to overdefined.
+func computeSCCP(f *ssa.Function, sizes types.Sizes) *sccp {
+	s := &sccp{
+		values: make(map[ssa.Value]lattice),
+		exec:   make(map[*ssa.BasicBlock]bool),
+		sizes:  sizes,
+	}
+	if len(f.Blocks) == 0 {
+		return s
+	}
+	edges := make(map[[2]*ssa.BasicBlock]bool)
+	s.exec[f.Blocks[0]] = true
+	if f.Recover != nil {
+		s.exec[f.Recover] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			if !s.exec[b] {
+				continue
+			}
+			for _, i := range b.Instrs {
+				if v, ok := i.(ssa.Value); ok {
+					l := s.eval(v, edges)
+					if old := s.values[v]; old.state != l.state {
+						s.values[v] = l
+						changed = true
+					} else if l.state == constVal && old.state == constVal && !constant.Compare(old.val, token.EQL, l.val) {
+						s.values[v] = lattice{state: overdefined}
+						changed = true
+					}
+				}
+			}
+			var succs []*ssa.BasicBlock
+			if i, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If); ok {
+				switch c := s.value(i.Cond); {
+				case c.state == constVal && constant.BoolVal(c.val):
+					succs = b.Succs[:1]
+				case c.state == constVal:
+					succs = b.Succs[1:]
+				case c.state == overdefined:
+					succs = b.Succs
+				}
+			} else {
+				succs = b.Succs
+			}
+			for _, succ := range succs {
+				if !edges[[2]*ssa.BasicBlock{b, succ}] {
+					edges[[2]*ssa.BasicBlock{b, succ}] = true
+					s.exec[succ] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return s
+}
+
+// value returns the lattice value of an operand.
+func (s *sccp) value(v ssa.Value) lattice {
+	if c, ok := v.(*ssa.Const); ok {
+		if c.Value == nil {
+			return lattice{state: overdefined}
+		}
+		return lattice{constVal, c.Value}
+	}
+	if !isRegister(v) {
+		return lattice{state: overdefined}
+	}
+	if _, ok := v.(ssa.Instruction); !ok {
+		// Parameters and free variables.
+		return lattice{state: overdefined}
+	}
+	return s.values[v]
+}
+
+// eval computes the lattice value of the instruction v.
+func (s *sccp) eval(v ssa.Value, edges map[[2]*ssa.BasicBlock]bool) lattice {
+	switch v := v.(type) {
+	case *ssa.Phi:
+		l := lattice{state: undefined}
+		for k, e := range v.Edges {
+			if !edges[[2]*ssa.BasicBlock{v.Block().Preds[k], v.Block()}] {
+				continue
+			}
+			l = meet(l, s.value(e))
+		}
+		return l
+	case *ssa.BinOp:
+		x, y := s.value(v.X), s.value(v.Y)
+		if x.state == overdefined || y.state == overdefined {
+			return lattice{state: overdefined}
+		}
+		if x.state == undefined || y.state == undefined {
+			return lattice{state: undefined}
+		}
+		return s.fold(binOp(v.Op, x.val, y.val, v.X.Type()), v.Type())
+	case *ssa.UnOp:
+		x := s.value(v.X)
+		if x.state != constVal {
+			return x
+		}
+		switch v.Op {
+		case token.SUB, token.NOT:
+			return s.fold(constant.UnaryOp(v.Op, x.val, 0), v.Type())
+		case token.XOR:
+			return s.fold(constant.UnaryOp(v.Op, x.val, s.precision(v.Type())), v.Type())
+		}
+	case *ssa.Convert:
+		x := s.value(v.X)
+		if x.state != constVal {
+			return x
+		}
+		return s.fold(convert(x.val, v.Type()), v.Type())
+	}
+	return lattice{state: overdefined}
+}
+
+// meet combines the values flowing into a Phi node.
+func meet(a, b lattice) lattice {
+	switch {
+	case a.state == undefined:
+		return b
+	case b.state == undefined:
+		return a
+	case a.state == constVal && b.state == constVal && constant.Compare(a.val, token.EQL, b.val):
+		return a
+	}
+	return lattice{state: overdefined}
+}
+
+// binOp evaluates x op y the way Go does at run time for operands of
+// type t.  It returns nil if the operation can not be folded.
+func binOp(op token.Token, x, y constant.Value, t types.Type) constant.Value {
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		if x.Kind() != y.Kind() && !isNumeric(x) {
+			return nil
+		}
+		return constant.MakeBool(constant.Compare(x, op, y))
+	case token.SHL, token.SHR:
+		s, ok := constant.Uint64Val(constant.ToInt(y))
+		if !ok || s > 64 {
+			return nil
+		}
+		return constant.Shift(x, op, uint(s))
+	case token.QUO, token.REM:
+		if constant.Sign(y) == 0 {
+			return nil
+		}
+		if op == token.QUO && isInteger(t) {
+			op = token.QUO_ASSIGN
+		}
+	}
+	if x.Kind() == constant.Unknown || y.Kind() == constant.Unknown {
+		return nil
+	}
+	return constant.BinaryOp(x, op, y)
+}
+
+// convert converts x to the basic type t.  It returns nil for conversions
+// which are not folded, e.g. to strings.
+func convert(x constant.Value, t types.Type) constant.Value {
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return nil
+	}
+	switch {
+	case b.Info()&types.IsInteger != 0:
+		if i := constant.ToInt(x); i.Kind() == constant.Int {
+			return i
+		}
+		// Float to integer conversions truncate.  ToInt only converts
+		// floats without a fractional part.
+		f, _ := constant.Float64Val(constant.ToFloat(x))
+		if math.IsNaN(f) || math.Abs(f) >= 1<<63 {
+			return nil
+		}
+		return constant.MakeInt64(int64(math.Trunc(f)))
+	case b.Info()&types.IsFloat != 0:
+		return constant.ToFloat(x)
+	}
+	return nil
+}
+
+// fold wraps the result of a folded operation of type t into a lattice
+// value.  Values which do not fit into t, e.g. because they would
+// overflow at run time, are not folded.
+func (s *sccp) fold(v constant.Value, t types.Type) lattice {
+	v = roundFloat(v, t)
+	if v == nil || v.Kind() == constant.Unknown || !s.fits(v, t) {
+		return lattice{state: overdefined}
+	}
+	return lattice{constVal, v}
+}
+
+// roundFloat rounds v to the precision of the float type t, as the
+// operations of that type do at run time; constant arithmetic is exact.
+// It returns nil for values which overflow to an infinity.
+func roundFloat(v constant.Value, t types.Type) constant.Value {
+	b, ok := t.Underlying().(*types.Basic)
+	if v == nil || !ok || b.Info()&types.IsFloat == 0 || !isNumeric(v) {
+		return v
+	}
+	f, _ := constant.Float64Val(constant.ToFloat(v))
+	if b.Kind() == types.Float32 {
+		f = float64(float32(f))
+	}
+	if math.IsInf(f, 0) {
+		return nil
+	}
+	return constant.MakeFloat64(f)
+}
+
+func isNumeric(v constant.Value) bool {
+	switch v.Kind() {
+	case constant.Int, constant.Float, constant.Complex:
+		return true
+	}
+	return false
+}
+
+func isInteger(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsInteger != 0
+}
+
+// precision returns the bit size of an unsigned integer type t and 0 for
+// every other type, as required by constant.UnaryOp.
+func (s *sccp) precision(t types.Type) uint {
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok || b.Info()&types.IsUnsigned == 0 {
+		return 0
+	}
+	return uint(8 * s.sizes.Sizeof(b))
+}
+
+// fits reports whether v is representable by a value of type t.
+func (s *sccp) fits(v constant.Value, t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	if b.Info()&types.IsInteger == 0 {
+		return true
+	}
+	if v.Kind() != constant.Int {
+		return false
+	}
+	bits := uint(8 * s.sizes.Sizeof(b))
+	if b.Info()&types.IsUnsigned != 0 {
+		return constant.Sign(v) >= 0 && constant.BitLen(v) <= int(bits)
+	}
+	min := constant.Shift(constant.MakeInt64(-1), token.SHL, bits-1)
+	max := constant.BinaryOp(constant.UnaryOp(token.SUB, min, 0), token.SUB, constant.MakeInt64(1))
+	return constant.Compare(min, token.LEQ, v) && constant.Compare(v, token.LEQ, max)
+}
+
+// folded returns the constant value of v, if any, as a string.
+func (s *sccp) folded(i ssa.Instruction) string {
+	v, ok := i.(ssa.Value)
+	if !ok {
+		return ""
+	}
+	if l := s.values[v]; l.state == constVal {
+		return l.val.String()
+	}
+	return ""
+}
+
+// branches returns the If instructions of f whose condition is constant.
+func (s *sccp) branches(f *ssa.Function, du *defUse) []Branch {
+	var bs []Branch
+	for _, b := range f.Blocks {
+		if !s.exec[b] || len(b.Instrs) == 0 {
+			continue
+		}
+		i, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If)
+		if !ok {
+			continue
+		}
+		if c := s.value(i.Cond); c.state == constVal {
+			bs = append(bs, Branch{du.id(i), b.Index, i.Cond.Name(), constant.BoolVal(c.val), posString(f.Prog, i.Cond.Pos())})
+		}
+	}
+	return bs
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		x    constant.Value
+		t    types.BasicKind
+		want string // "" if not folded
+	}{
+		{constant.MakeFloat64(2.5), types.Int, "2"},
+		{constant.MakeFloat64(-2.5), types.Int, "-2"},
+		{constant.MakeFloat64(3), types.Int, "3"},
+		{constant.MakeFloat64(0.75), types.Uint8, "0"},
+		{constant.MakeInt64(7), types.Float64, "7"},
+		{constant.MakeInt64(-1), types.Int8, "-1"},
+		{constant.MakeString("s"), types.String, ""},
+	}
+	for _, tt := range tests {
+		got := convert(tt.x, types.Typ[tt.t])
+		s := ""
+		if got != nil {
+			s = got.String()
+		}
+		if s != tt.want {
+			t.Errorf("convert(%v, %s) = %q, want %q", tt.x, types.Typ[tt.t], s, tt.want)
+		}
+	}
+}
+
+func TestFoldOverflow(t *testing.T) {
+	s := &sccp{sizes: types.SizesFor("gc", "386")}
+	tests := []struct {
+		x    constant.Value
+		t    types.BasicKind
+		want int
+	}{
+		{constant.MakeFloat64(1e300), types.Int64, overdefined},
+		{constant.MakeInt64(1 << 40), types.Int, overdefined},
+		{constant.MakeInt64(1 << 40), types.Int64, constVal},
+		{constant.MakeInt64(-1), types.Uint8, overdefined},
+		{constant.MakeInt64(255), types.Uint8, constVal},
+		{constant.MakeFloat64(1e300), types.Float32, overdefined},
+	}
+	for _, tt := range tests {
+		typ := types.Typ[tt.t]
+		if got := s.fold(convert(tt.x, typ), typ); got.state != tt.want {
+			t.Errorf("fold(%v, %s): state %d, want %d", tt.x, typ, got.state, tt.want)
+		}
+	}
+}
+
+// TestFoldFloat checks that folded floats are rounded to their type.
+func TestFoldFloat(t *testing.T) {
+	s := &sccp{sizes: types.SizesFor("gc", "amd64")}
+	third := constant.BinaryOp(constant.MakeInt64(1), token.QUO, constant.MakeInt64(3))
+	tests := []struct {
+		t    types.BasicKind
+		want float64
+	}{
+		{types.Float32, float64(float32(1) / 3)},
+		{types.Float64, float64(1) / 3},
+	}
+	for _, tt := range tests {
+		typ := types.Typ[tt.t]
+		got := s.fold(third, typ)
+		if f, _ := constant.Float64Val(got.val); got.state != constVal || f != tt.want {
+			t.Errorf("fold(1/3, %s) = %v, want %v", typ, got.val, tt.want)
+		}
+	}
+}
+
+func TestBinOp(t *testing.T) {
+	i, f := types.Typ[types.Int], types.Typ[types.Float64]
+	tests := []struct {
+		op   token.Token
+		x, y constant.Value
+		t    types.Type
+		want string
+	}{
+		{token.QUO, constant.MakeInt64(7), constant.MakeInt64(2), i, "3"},
+		{token.QUO, constant.MakeFloat64(7), constant.MakeFloat64(2), f, "3.5"},
+		{token.REM, constant.MakeInt64(7), constant.MakeInt64(0), i, ""},
+		{token.SHL, constant.MakeInt64(1), constant.MakeInt64(65), i, ""},
+		{token.SHL, constant.MakeInt64(1), constant.MakeInt64(3), i, "8"},
+		{token.LSS, constant.MakeInt64(1), constant.MakeInt64(2), i, "true"},
+		{token.EQL, constant.MakeString("a"), constant.MakeInt64(2), i, ""},
+	}
+	for _, tt := range tests {
+		got := binOp(tt.op, tt.x, tt.y, tt.t)
+		s := ""
+		if got != nil {
+			s = got.String()
+		}
+		if s != tt.want {
+			t.Errorf("%v %s %v = %q, want %q", tt.x, tt.op, tt.y, s, tt.want)
+		}
+	}
+}
+
+func TestSCCPBranches(t *testing.T) {
+	tests := []struct {
+		name, body string
+		always     bool
+	}{
+		{"fraction", "x := 2.5\n\ti := int(x)\n\tif i == 0 {\n\t\treturn 1\n\t}\n\treturn 2", false},
+		{"negative", "x := -0.5\n\ti := int(x)\n\tif i == 0 {\n\t\treturn 1\n\t}\n\treturn 2", true},
+		{"shift", "x := 1\n\tif x<<3 == 8 {\n\t\treturn 1\n\t}\n\treturn 2", true},
+	}
+	sizes := types.SizesFor("gc", "amd64")
+	for _, tt := range tests {
+		f := testFunc(t, "package main\n\nfunc f() int {\n\t"+tt.body+"\n}\n", "f")
+		s := computeSCCP(f, sizes)
+		bs := s.branches(f, newDefUse(f))
+		if len(bs) != 1 || bs[0].Always != tt.always {
+			t.Errorf("%s: got branches %+v, want one always %v", tt.name, bs, tt.always)
+			continue
+		}
+		// The block of the branch which is not taken is dead.
+		b := f.Blocks[bs[0].Block]
+		taken, dead := b.Succs[0], b.Succs[1]
+		if !tt.always {
+			taken, dead = dead, taken
+		}
+		if !s.exec[taken] || s.exec[dead] {
+			t.Errorf("%s: block %d executed %v, block %d executed %v", tt.name, taken.Index, s.exec[taken], dead.Index, s.exec[dead])
+		}
+	}
+}
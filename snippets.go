@@ -0,0 +1,246 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Snippet is a submitted source together with its render options, the
+// other fields of the form.
+type Snippet struct {
+	Source  string
+	Options url.Values
+}
+
+// SnippetStore keeps snippets by a short ID derived from their content,
+// so storing the same snippet twice yields the same ID.
+type SnippetStore interface {
+	Put(s Snippet) (id string, err error)
+	Get(id string) (Snippet, error)
+}
+
+var (
+	errNoSnippet       = errors.New("snippet not found")
+	errSnippetTooLarge = errors.New("snippet too large to keep")
+	errNoSnippetID     = errors.New("no free snippet ID")
+)
+
+// maxSnippetIDs is the number of IDs tried for a snippet whose ID is taken
+// by another one.
+const maxSnippetIDs = 10
+
+// snippets is the store of the server: in memory unless SNIPPET_DIR names
+// a directory to keep them in, see loadSnippets.
+var snippets SnippetStore = newMemStore(1000)
+
+// maxSnippetBytes is the size of the largest snippet kept, its source and
+// its encoded options: SNIPPET_MAX_BYTES.
+var maxSnippetBytes = 128 << 10
+
+// loadSnippets configures the snippet store from the environment.  Both
+// stores keep the SNIPPET_COUNT most recently used snippets.
+func loadSnippets() error {
+	count := 1000
+	for _, v := range []struct {
+		name string
+		n    *int
+	}{{"SNIPPET_COUNT", &count}, {"SNIPPET_MAX_BYTES", &maxSnippetBytes}} {
+		if s := os.Getenv(v.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return fmt.Errorf("bad %s %q, want a number", v.name, s)
+			}
+			*v.n = n
+		}
+	}
+	if dir := os.Getenv("SNIPPET_DIR"); dir != "" {
+		ds, err := newDiskStore(dir, count)
+		if err != nil {
+			return err
+		}
+		snippets = ds
+		return nil
+	}
+	snippets = newMemStore(count)
+	return nil
+}
+
+var snippetID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// newSnippet returns the snippet of a submitted form.  Empty options and
+// the default taint spec are left out to keep permalinks short.
+func newSnippet(form url.Values) Snippet {
+	s := Snippet{form.Get("source"), make(url.Values)}
+	for k, vs := range form {
+		if k == "source" {
+			continue
+		}
+		for _, v := range vs {
+			v = strings.Replace(v, "\r\n", "\n", -1)
+			if v == "" || k == "taint" && strings.TrimSpace(v) == strings.TrimSpace(defaultTaintSpec) {
+				continue
+			}
+			s.Options.Add(k, v)
+		}
+	}
+	return s
+}
+
+// id is the first 10 characters of the URL safe base64 encoded SHA-256
+// sum of the source and the encoded options.  Should it be taken by
+// another snippet, the n-th retry adds n to the sum.
+func (s Snippet) id(n int) string {
+	text := s.text()
+	if n > 0 {
+		text += "\x00" + strconv.Itoa(n)
+	}
+	sum := sha256.Sum256([]byte(text))
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:10]
+}
+
+// text is the content of s the ID is derived from.
+func (s Snippet) text() string {
+	return s.Source + "\x00" + s.Options.Encode()
+}
+
+// size returns the number of bytes counted against maxSnippetBytes.
+func (s Snippet) size() int {
+	return len(s.Source) + len(s.Options.Encode())
+}
+
+// form returns the snippet as a submitted form.
+func (s Snippet) form() url.Values {
+	f := make(url.Values)
+	for k, vs := range s.Options {
+		f[k] = append([]string(nil), vs...)
+	}
+	f.Set("source", s.Source)
+	return f
+}
+
+// memStore keeps the max most recently used snippets in memory.
+type memStore struct {
+	mu       sync.Mutex
+	max      int
+	lru      *list.List
+	snippets map[string]*list.Element
+}
+
+type snippetEntry struct {
+	id string
+	s  Snippet
+}
+
+func newMemStore(max int) *memStore {
+	return &memStore{max: max, lru: list.New(), snippets: make(map[string]*list.Element)}
+}
+
+func (m *memStore) Put(s Snippet) (string, error) {
+	if s.size() > maxSnippetBytes {
+		return "", errSnippetTooLarge
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n := 0; n < maxSnippetIDs; n++ {
+		id := s.id(n)
+		e, ok := m.snippets[id]
+		if !ok {
+			m.add(id, s)
+			return id, nil
+		}
+		if e.Value.(*snippetEntry).s.text() == s.text() {
+			m.lru.MoveToFront(e)
+			return id, nil
+		}
+	}
+	return "", errNoSnippetID
+}
+
+// add puts s in the store, dropping the least recently used snippets
+// beyond the maximum; m.mu is held.
+func (m *memStore) add(id string, s Snippet) {
+	m.snippets[id] = m.lru.PushFront(&snippetEntry{id, s})
+	for m.lru.Len() > m.max {
+		e := m.lru.Back()
+		m.lru.Remove(e)
+		delete(m.snippets, e.Value.(*snippetEntry).id)
+	}
+}
+
+func (m *memStore) Get(id string) (Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.snippets[id]
+	if !ok {
+		return Snippet{}, errNoSnippet
+	}
+	m.lru.MoveToFront(e)
+	return e.Value.(*snippetEntry).s, nil
+}
+
+// diskStore keeps every snippet as a JSON file named by its ID, the
+// least recently used ones beyond a maximum are removed.
+type diskStore struct {
+	files *diskLRU
+	mu    sync.Mutex // held by Put from checking an ID to taking it
+}
+
+func newDiskStore(dir string, max int) (*diskStore, error) {
+	l, err := newDiskLRU(dir, ".json", max)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStore{files: l}, nil
+}
+
+func (d *diskStore) Put(s Snippet) (string, error) {
+	if s.size() > maxSnippetBytes {
+		return "", errSnippetTooLarge
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for n := 0; n < maxSnippetIDs; n++ {
+		id := s.id(n)
+		old, err := d.Get(id)
+		if err == errNoSnippet {
+			return id, d.files.write(id, b)
+		}
+		if err != nil {
+			return "", err
+		}
+		if old.text() == s.text() {
+			return id, nil
+		}
+	}
+	return "", errNoSnippetID
+}
+
+func (d *diskStore) Get(id string) (Snippet, error) {
+	var s Snippet
+	if !snippetID.MatchString(id) {
+		return s, errNoSnippet
+	}
+	b, err := d.files.read(id)
+	if os.IsNotExist(err) {
+		return s, errNoSnippet
+	}
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
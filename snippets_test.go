@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSnippet(t *testing.T) {
+	form := url.Values{
+		"source":   {"package main\r\n"},
+		"query":    {"alloc heap"},
+		"passes":   {""},
+		"taint":    {defaultTaintSpec},
+		"analysis": {"nilness", "taint"},
+	}
+	s := newSnippet(form)
+	want := url.Values{"query": {"alloc heap"}, "analysis": {"nilness", "taint"}}
+	if !reflect.DeepEqual(s.Options, want) {
+		t.Errorf("options %v, want %v", s.Options, want)
+	}
+	if s.id(0) != newSnippet(form).id(0) {
+		t.Errorf("the ids of equal snippets differ")
+	}
+	f := s.form()
+	if f.Get("source") != "package main\r\n" || f.Get("query") != "alloc heap" {
+		t.Errorf("form %v", f)
+	}
+}
+
+// testStore stores the snippets a, b and c in a store keeping two and
+// checks that the least recently used one is dropped.
+func testStore(t *testing.T, st SnippetStore, touch func()) {
+	a, b, c := Snippet{Source: "a"}, Snippet{Source: "b"}, Snippet{Source: "c"}
+	ida, err := st.Put(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idb, _ := st.Put(b)
+	touch()
+	if s, err := st.Get(ida); err != nil || s.Source != "a" {
+		t.Fatalf("get a: %v %v", s, err)
+	}
+	touch()
+	idc, _ := st.Put(c)
+	if _, err := st.Get(idb); err != errNoSnippet {
+		t.Errorf("get b: error %v, want it evicted", err)
+	}
+	for _, id := range []string{ida, idc} {
+		if _, err := st.Get(id); err != nil {
+			t.Errorf("get %s: %v", id, err)
+		}
+	}
+	if _, err := st.Get("nosuchid"); err != errNoSnippet {
+		t.Errorf("get nosuchid: error %v", err)
+	}
+	if _, err := st.Put(Snippet{Source: strings.Repeat("x", maxSnippetBytes+1)}); err != errSnippetTooLarge {
+		t.Errorf("put large snippet: error %v", err)
+	}
+}
+
+// testTakenID checks that a snippet whose ID is taken by another one,
+// which take puts in the store st, gets another ID.
+func testTakenID(t *testing.T, st SnippetStore, take func(id string, s Snippet)) {
+	s, other := Snippet{Source: "s"}, Snippet{Source: "other"}
+	take(s.id(0), other)
+	id, err := st.Put(s)
+	if err != nil || id != s.id(1) {
+		t.Errorf("put: %q, %v, want %q", id, err, s.id(1))
+	}
+	if again, _ := st.Put(s); again != id {
+		t.Errorf("put again: %q, want %q", again, id)
+	}
+	if got, err := st.Get(s.id(0)); err != nil || got.Source != "other" {
+		t.Errorf("get %s: %v %v, want the other snippet", s.id(0), got, err)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, newMemStore(2), func() {})
+	m := newMemStore(2)
+	testTakenID(t, m, m.add)
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snippets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := newDiskStore(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// File times may be coarse; wait for them to differ.
+	testStore(t, st, func() { time.Sleep(20 * time.Millisecond) })
+	fs, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(fs) != 2 {
+		t.Errorf("files %v, want 2", fs)
+	}
+	// A store opened on a full directory evicts down to its maximum.
+	if _, err := newDiskStore(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fs, _ := filepath.Glob(filepath.Join(dir, "*")); len(fs) != 1 {
+		t.Errorf("files %v, want 1", fs)
+	}
+	testTakenID(t, st, func(id string, s Snippet) {
+		b, _ := json.Marshal(s)
+		if err := st.files.write(id, b); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// failStore is a snippet store which cannot store snippets.
+type failStore struct{}
+
+func (failStore) Put(s Snippet) (string, error)  { return "", errors.New("disk full") }
+func (failStore) Get(id string) (Snippet, error) { return Snippet{}, errNoSnippet }
+
+func TestShowPermalink(t *testing.T) {
+	defer func(old SnippetStore) { snippets = old }(snippets)
+	tests := []struct {
+		store SnippetStore
+		want  bool
+	}{
+		{newMemStore(1), true},
+		{failStore{}, false},
+	}
+	for _, tt := range tests {
+		snippets = tt.store
+		form := url.Values{"source": {"package main\n\nfunc main() {}\n"}, "run": {"none"}}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		w := httptest.NewRecorder()
+		show(w, r)
+		if got := strings.Contains(w.Body.String(), `href="/s/`); got != tt.want {
+			t.Errorf("%T: permalink %v, want %v", tt.store, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// defaultTaintSpec is used if no spec is given.
+const defaultTaintSpec = `# kind function
+source net/http.Request.FormValue
+source net/http.Request.PostFormValue
+source os.Getenv
+sink database/sql.DB.Query
+sink database/sql.DB.QueryRow
+sink database/sql.DB.Exec
+sink os/exec.Command
+sanitizer html.EscapeString
+sanitizer strconv.Quote
+`
+
+// TaintPath is a flow of a value returned by a source to an argument of a
+// sink, starting with the call of the source.
+type TaintPath struct {
+	Source string
+	Sink   string
+	Steps  []TaintStep
+	instrs []ssa.Instruction // of Steps
+	sink   ssa.Instruction
+}
+
+// TaintStep is an instruction on a TaintPath.
+type TaintStep struct {
+	Func  string
+	ID    string
+	Instr string
+	Pos   string
+}
+
+// taintSpec holds the functions named in a spec by their package path,
+// the receiver type name for methods and the function name, e.g.
+// net/http.Request.FormValue.
+type taintSpec struct {
+	sources, sinks, sanitizers map[string]bool
+}
+
+// parseTaintSpec parses one "kind function" pair per line, where kind is
+// source, sink or sanitizer.  Empty lines and lines starting with # are
+// ignored.
+func parseTaintSpec(spec string) (*taintSpec, error) {
+	ts := &taintSpec{make(map[string]bool), make(map[string]bool), make(map[string]bool)}
+	s := bufio.NewScanner(strings.NewReader(spec))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("taint spec:%d: want \"kind function\", got %q", n, line)
+		}
+		switch f[0] {
+		case "source":
+			ts.sources[f[1]] = true
+		case "sink":
+			ts.sinks[f[1]] = true
+		case "sanitizer":
+			ts.sanitizers[f[1]] = true
+		default:
+			return nil, fmt.Errorf("taint spec:%d: unknown kind %q, want source, sink or sanitizer", n, f[0])
+		}
+	}
+	return ts, s.Err()
+}
+
+// specName returns the name of a function as used in a spec.
+func specName(fn *types.Func) string {
+	if fn.Pkg() == nil {
+		return fn.Name()
+	}
+	name := fn.Pkg().Path() + "."
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		t := recv.Type()
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		if named, ok := t.(*types.Named); ok {
+			name += named.Obj().Name() + "."
+		}
+	}
+	return name + fn.Name()
+}
+
+// taintContent is the node of the contents of an object of the pointer
+// analysis; taintReturn the node of the results of a function.
+type (
+	taintContent struct{ obj ssa.Value }
+	taintReturn  struct{ fn *ssa.Function }
+)
+
+// taintFact tells why a node is tainted: at the instruction at, taint
+// flowed from the node prev, which is nil for the result of a source.
+type taintFact struct {
+	prev interface{}
+	at   ssa.Instruction
+}
+
+// taint propagates taint forward along the SSA data flow of a package.
+// Stores and loads go through the objects of the pointer analysis, so all
+// fields and elements of an object are tainted together.  Calls of
+// functions of the package pass taint to their parameters and back from
+// their results; the results of other functions are tainted if any
+// argument is, unless the function is a sanitizer.
+type taint struct {
+	spec    *taintSpec
+	pa      *pointer
+	funcs   map[*ssa.Function]bool // of the package
+	facts   map[interface{}]taintFact
+	sinks   map[ssa.CallInstruction]interface{} // call -> tainted argument
+	changed bool
+}
+
+// analyzeTaint finds the paths from sources to sinks in pkg, one for
+// every call of a sink with a tainted argument.  It stops with errDeadline
+// once ctx is done.
+func analyzeTaint(ctx context.Context, pkg *ssa.Package, pa *pointer, spec *taintSpec) ([]TaintPath, error) {
+	t := &taint{
+		spec:  spec,
+		pa:    pa,
+		facts: make(map[interface{}]taintFact),
+		sinks: make(map[ssa.CallInstruction]interface{}),
+		funcs: make(map[*ssa.Function]bool),
+	}
+	fs := pkgFuncs(pkg)
+	for _, f := range fs {
+		t.funcs[f] = true
+	}
+	for t.changed = true; t.changed; {
+		t.changed = false
+		for _, f := range fs {
+			if ctx.Err() != nil {
+				return nil, errDeadline
+			}
+			for _, b := range f.Blocks {
+				for _, i := range b.Instrs {
+					t.instr(i)
+				}
+			}
+		}
+	}
+
+	dus := make(map[*ssa.Function]*defUse)
+	for _, f := range fs {
+		dus[f] = newDefUse(f)
+	}
+	step := func(i ssa.Instruction) TaintStep {
+		f := i.Parent()
+		return TaintStep{f.RelString(pkg.Pkg), dus[f].id(i), i.String(), posString(pkg.Prog, i.Pos())}
+	}
+	var paths []TaintPath
+	for c, n := range t.sinks {
+		instrs := []ssa.Instruction{c}
+		for n != nil {
+			fact := t.facts[n]
+			if fact.at != instrs[len(instrs)-1] {
+				instrs = append(instrs, fact.at)
+			}
+			n = fact.prev
+		}
+		var steps []TaintStep
+		for k, j := 0, len(instrs)-1; k < j; k, j = k+1, j-1 {
+			instrs[k], instrs[j] = instrs[j], instrs[k]
+		}
+		for _, i := range instrs {
+			steps = append(steps, step(i))
+		}
+		src := instrs[0].(ssa.CallInstruction).Common()
+		paths = append(paths, TaintPath{calleeName(src, pkg.Pkg), calleeName(c.Common(), pkg.Pkg), steps, instrs, c})
+	}
+	// Paths are ordered by the position of their sinks.
+	sort.Slice(paths, func(i, j int) bool {
+		a := pkg.Prog.Fset.Position(paths[i].sink.Pos())
+		b := pkg.Prog.Fset.Position(paths[j].sink.Pos())
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return paths, nil
+}
+
+// mark taints the node n if prev is tainted.
+func (t *taint) mark(n, prev interface{}, at ssa.Instruction) {
+	if prev != nil {
+		t.set(n, taintFact{prev, at})
+	}
+}
+
+func (t *taint) set(n interface{}, f taintFact) {
+	if _, ok := t.facts[n]; !ok {
+		t.facts[n] = f
+		t.changed = true
+	}
+}
+
+// of returns the tainted node of v, which is v itself or the contents of
+// an object v points to, or nil if v is not tainted.
+func (t *taint) of(v ssa.Value) interface{} {
+	if v == nil {
+		return nil
+	}
+	if _, ok := t.facts[v]; ok {
+		return v
+	}
+	for o := range t.pa.of(v) {
+		if _, ok := t.facts[taintContent{o}]; ok {
+			return taintContent{o}
+		}
+	}
+	return nil
+}
+
+// store taints the contents of the objects addr points to if val is
+// tainted.
+func (t *taint) store(addr, val ssa.Value, at ssa.Instruction) {
+	if n := t.of(val); n != nil {
+		for o := range t.pa.of(addr) {
+			t.mark(taintContent{o}, n, at)
+		}
+	}
+}
+
+func (t *taint) instr(i ssa.Instruction) {
+	switch i := i.(type) {
+	case *ssa.Store:
+		t.store(i.Addr, i.Val, i)
+	case *ssa.MapUpdate:
+		t.store(i.Map, i.Key, i)
+		t.store(i.Map, i.Value, i)
+	case *ssa.Send:
+		t.store(i.Chan, i.X, i)
+	case *ssa.MakeClosure:
+		fn := i.Fn.(*ssa.Function)
+		for k, b := range i.Bindings {
+			t.mark(fn.FreeVars[k], t.of(b), i)
+		}
+	case *ssa.Return:
+		for _, r := range i.Results {
+			t.mark(taintReturn{i.Parent()}, t.of(r), i)
+		}
+	case ssa.CallInstruction:
+		t.call(i)
+	default:
+		v, ok := i.(ssa.Value)
+		if !ok {
+			return
+		}
+		var ops []ssa.Value
+		for _, op := range i.Operands(nil) {
+			if *op != nil {
+				ops = append(ops, *op)
+			}
+		}
+		t.mark(v, t.anyOf(ops), i)
+	}
+}
+
+// anyOf returns the tainted node of the first tainted value of vs.
+func (t *taint) anyOf(vs []ssa.Value) interface{} {
+	for _, v := range vs {
+		if n := t.of(v); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+func (t *taint) call(c ssa.CallInstruction) {
+	common := c.Common()
+	args := common.Args
+	if common.IsInvoke() {
+		args = append([]ssa.Value{common.Value}, args...)
+	}
+	var v ssa.Value
+	if cv := c.Value(); cv != nil {
+		v = cv
+	}
+	tainted := t.anyOf(args)
+
+	var names []string
+	var callees []*ssa.Function
+	switch {
+	case common.IsInvoke():
+		names = append(names, specName(common.Method))
+		for f := range t.pa.callees[c] {
+			callees = append(callees, f)
+		}
+	case common.StaticCallee() != nil:
+		callees = append(callees, common.StaticCallee())
+	default:
+		for f := range t.pa.callees[c] {
+			callees = append(callees, f)
+		}
+	}
+	for _, f := range callees {
+		if obj, ok := f.Object().(*types.Func); ok {
+			names = append(names, specName(obj))
+		}
+	}
+
+	for _, name := range names {
+		if t.spec.sinks[name] && tainted != nil {
+			if _, ok := t.sinks[c]; !ok {
+				t.sinks[c] = tainted
+			}
+		}
+		if v == nil {
+			continue
+		}
+		if t.spec.sources[name] {
+			t.set(v, taintFact{nil, c})
+		}
+		if t.spec.sanitizers[name] {
+			return
+		}
+	}
+
+	external := len(callees) == 0
+	for _, f := range callees {
+		if !t.funcs[f] {
+			external = true
+			continue
+		}
+		// For invoke, the interface passes the taint of its dynamic value
+		// to the receiver.
+		for k, a := range args {
+			if k < len(f.Params) {
+				t.mark(f.Params[k], t.of(a), c)
+			}
+		}
+		if v != nil {
+			t.mark(v, t.returned(f), c)
+		}
+	}
+	if external && v != nil {
+		t.mark(v, tainted, c)
+	}
+}
+
+// returned returns the node of the results of f if they are tainted.
+func (t *taint) returned(f *ssa.Function) interface{} {
+	if _, ok := t.facts[taintReturn{f}]; ok {
+		return taintReturn{f}
+	}
+	return nil
+}
+
+func init() {
+	register(taintAnalysis{})
+}
+
+// taintAnalysis reports every path as a diagnostic at the call of the
+// sink, with the steps before it as related findings.  The steps are also
+// annotated.  The spec is taken from the taint option.
+type taintAnalysis struct{}
+
+func (taintAnalysis) Name() string { return "taint" }
+func (taintAnalysis) Doc() string {
+	return "report flows from the results of source functions to arguments of sink functions, see the taint spec"
+}
+
+func (taintAnalysis) Run(in *AnalysisInput) (*Report, error) {
+	ts, err := parseTaintSpec(in.Options["taint"].(string))
+	if err != nil {
+		return nil, err
+	}
+	pa, err := in.pointers()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := analyzeTaint(in.context(), in.Pkg, pa, ts)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	for _, p := range paths {
+		d := Finding{Instr: p.sink, Message: fmt.Sprintf("value from %s at %s reaches %s", p.Source, p.Steps[0].Pos, p.Sink)}
+		for k, s := range p.instrs[:len(p.instrs)-1] {
+			d.Related = append(d.Related, Finding{Instr: s, Message: p.Steps[k].Instr})
+			r.Annotations = append(r.Annotations, Finding{Instr: s, Message: fmt.Sprintf("step %d from %s to %s", k+1, p.Source, p.Sink)})
+		}
+		r.Diagnostics = append(r.Diagnostics, d)
+	}
+	return r, nil
+}
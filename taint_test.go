@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeTaint(t *testing.T) {
+	src := `package main
+
+func src() string       { return "" }
+func sink(s string)     {}
+func clean(string) string { return "" }
+func id(s string) string  { return s }
+
+func main() {
+	sink(src())
+	s := src()
+	sink(clean(s))
+	t := id(s)
+	p := &struct{ s string }{}
+	p.s = t + "!"
+	sink(p.s)
+}
+`
+	spec, err := parseTaintSpec("source main.go.src\nsink main.go.sink\nsanitizer main.go.clean\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := testPackage(t, src)
+	var got []string
+	pa, err := analyzePointers(context.Background(), pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := analyzeTaint(context.Background(), pkg, pa, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		var steps []string
+		for _, s := range p.Steps {
+			steps = append(steps, fmt.Sprintf("%s %s", s.Func, strings.TrimPrefix(s.Pos, "main.go:")))
+		}
+		got = append(got, p.Source+" -> "+p.Sink+": "+strings.Join(steps, ", "))
+	}
+	// The paths are ordered by line, not by the text of the positions.
+	want := []string{
+		"src -> sink: main 9:10, main 9:6",
+		"src -> sink: main 10:10, main 12:9, id 6:29, main 12:9, main 14:10, main 14:4, main 15:9, main 15:9, main 15:6",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseTaintSpec(t *testing.T) {
+	for _, tt := range []struct{ spec, err string }{
+		{"# comment\n\nsource a.f\nsink b.T.m\nsanitizer c.g\n", ""},
+		{"source", `taint spec:1: want "kind function", got "source"`},
+		{"\nfilter a.f", `taint spec:2: unknown kind "filter", want source, sink or sanitizer`},
+	} {
+		_, err := parseTaintSpec(tt.spec)
+		if got := fmt.Sprint(err); err == nil && tt.err != "" || err != nil && got != tt.err {
+			t.Errorf("%q: error %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
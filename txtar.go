@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// archiveFile is a file of a txtar archive.
+type archiveFile struct {
+	Name string
+	Data []byte
+}
+
+// parseArchive splits src into the files of a txtar archive, as used by
+// the Go playground: every file starts with a line "-- name --".  Text
+// before the first such line belongs to a file named name, unless it is
+// blank, so a plain Go source is an archive of one file.
+func parseArchive(src []byte, name string) ([]archiveFile, error) {
+	var files []archiveFile
+	seen := make(map[string]bool)
+	cur := &archiveFile{Name: name}
+	add := func() error {
+		if cur.Name == name && len(files) == 0 && len(bytes.TrimSpace(cur.Data)) == 0 {
+			return nil
+		}
+		if seen[cur.Name] {
+			return fmt.Errorf("duplicate file %s", cur.Name)
+		}
+		seen[cur.Name] = true
+		files = append(files, *cur)
+		return nil
+	}
+	for len(src) > 0 {
+		line := src
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line = src[:i+1]
+		}
+		src = src[len(line):]
+		if n, ok := fileMarker(line); ok {
+			if err := add(); err != nil {
+				return nil, err
+			}
+			if strings.Contains(n, "/") {
+				return nil, fmt.Errorf("file %s: only files of one package are supported", n)
+			}
+			cur = &archiveFile{Name: n}
+			continue
+		}
+		cur.Data = append(cur.Data, line...)
+	}
+	if err := add(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files")
+	}
+	return files, nil
+}
+
+// fileMarker returns the name of a "-- name --" line.
+func fileMarker(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") || len(s) < len("-- x --") {
+		return "", false
+	}
+	n := strings.TrimSpace(s[3 : len(s)-3])
+	return n, n != ""
+}
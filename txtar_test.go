@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArchive(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []string // name: data
+		err       string
+	}{
+		{"plain source", "package main\n", []string{"main.go: package main\n"}, ""},
+		{"two files", "-- a.go --\npackage main\n-- b.go --\npackage main\n", []string{"a.go: package main\n", "b.go: package main\n"}, ""},
+		{"leading text", "package main\n-- b.go --\nx\n", []string{"main.go: package main\n", "b.go: x\n"}, ""},
+		{"blank leading text", "\n\n-- a.go --\nx\n", []string{"a.go: x\n"}, ""},
+		{"crlf", "-- a.go --\r\nx\r\n", []string{"a.go: x\r\n"}, ""},
+		{"empty file", "-- a.go --\n-- go.mod --\nmodule m\n", []string{"a.go: ", "go.mod: module m\n"}, ""},
+		{"no final newline", "-- a.go --\nx", []string{"a.go: x"}, ""},
+		{"not a marker", "-- a.go --\n--x--\n-- --\n", []string{"a.go: --x--\n-- --\n"}, ""},
+		{"duplicate", "-- a.go --\n-- a.go --\n", nil, "duplicate file a.go"},
+		{"duplicate of leading text", "x\n-- main.go --\n", nil, "duplicate file main.go"},
+		{"directory", "-- sub/a.go --\n", nil, "file sub/a.go: only files of one package are supported"},
+		{"empty", "", nil, "no files"},
+	}
+	for _, tt := range tests {
+		files, err := parseArchive([]byte(tt.src), "main.go")
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %s", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, fmt.Sprintf("%s: %s", f.Name, f.Data))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestArchiveBuild checks that the files of an archive form one package
+// and that errors name their file.
+func TestArchiveBuild(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"-- main.go --\npackage main\n\nfunc main() { f() }\n-- f.go --\npackage main\n\nfunc f() {}\n", ""},
+		{"-- main.go --\npackage main\n\nfunc main() { f() }\n-- f.go --\npackage main\n\nfunc f() { x }\n", "f.go:3:12: undefined: x"},
+		{"-- main.go --\npackage main\n\nfunc main() {}\n-- f.go --\npackage main\n\nfunc f( {}\n", "f.go:3:9"},
+		{"-- main.go --\npackage main\n\nfunc main() {}\n-- go.mod --\nmodule m\n", ""},
+	}
+	for _, tt := range tests {
+		o := newOptions()
+		_, err := toSSA(context.Background(), o, strings.NewReader(tt.src), "main.go", "main")
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%q: error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
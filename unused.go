@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+var errorType = types.Universe.Lookup("error").Type()
+
+func init() {
+	register(funcAnalysis{"unusedresult", "report calls whose error result is ignored or whose result is never used", checkUnusedResults})
+}
+
+// checkUnusedResults reports calls whose error result is thrown away and
+// calls whose result is never used at all.
+// With multiple results, an error is ignored if no Extract of its index
+// has referrers.  Calls of builtins are not reported.
+func checkUnusedResults(f *ssa.Function) []Finding {
+	var diags []Finding
+	report := func(c *ssa.Call, msg string) {
+		diags = append(diags, Finding{Instr: c, Message: msg})
+	}
+	for _, b := range f.Blocks {
+		for _, i := range b.Instrs {
+			c, ok := i.(*ssa.Call)
+			if !ok {
+				continue
+			}
+			if _, ok := c.Call.Value.(*ssa.Builtin); ok {
+				continue
+			}
+			callee := calleeName(&c.Call, f.Pkg.Pkg)
+			results := c.Call.Signature().Results()
+			if results.Len() == 0 {
+				continue
+			}
+			if len(*c.Referrers()) == 0 {
+				if hasError(results) {
+					report(c, "error returned by "+callee+" is ignored")
+				} else {
+					report(c, "result of "+callee+" is never used")
+				}
+				continue
+			}
+			if results.Len() == 1 {
+				continue
+			}
+			used := make(map[int]bool)
+			for _, r := range *c.Referrers() {
+				if e, ok := r.(*ssa.Extract); ok && len(*e.Referrers()) > 0 {
+					used[e.Index] = true
+				}
+			}
+			for k := 0; k < results.Len(); k++ {
+				if !used[k] && types.Identical(results.At(k).Type(), errorType) {
+					report(c, fmt.Sprintf("error returned by %s (result %d) is ignored", callee, k))
+				}
+			}
+		}
+	}
+	return diags
+}
+
+func hasError(t *types.Tuple) bool {
+	for k := 0; k < t.Len(); k++ {
+		if types.Identical(t.At(k).Type(), errorType) {
+			return true
+		}
+	}
+	return false
+}
+
+// calleeName returns a short description of the function called by c,
+// qualified relative to the package from.
+func calleeName(c *ssa.CallCommon, from *types.Package) string {
+	if c.IsInvoke() {
+		return c.Method.FullName()
+	}
+	if f := c.StaticCallee(); f != nil {
+		return f.RelString(from)
+	}
+	return c.Value.Name()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnusedResults(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []string
+	}{
+		{"ignored error", "g()", []string{"6: error returned by g is ignored"}},
+		{"unused result", "h()", []string{"6: result of h is never used"}},
+		{"used result", "println(h())", nil},
+		{"blank error", "n, _ := k()\n\tprintln(n)", []string{"6: error returned by k (result 1) is ignored"}},
+		{"checked error", "_, err := k()\n\tprintln(err)", nil},
+		{"builtin", "copy([]int(nil), []int{1})", nil},
+		{"method", "var e error\n\te.Error()", []string{"7: result of (error).Error is never used"}},
+	}
+	for _, tt := range tests {
+		src := "package main\n\nimport \"errors\"\n\nfunc f() {\n\t" + tt.body + "\n}\n\n" +
+			"func g() error { return errors.New(\"g\") }\n\nfunc h() int { return 1 }\n\n" +
+			"func k() (int, error) { return 0, g() }\n"
+		if got := findings(t, src, checkUnusedResults); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}